@@ -4,6 +4,9 @@ import (
     "fmt"
     "log"
     "os"
+    "strconv"
+    "strings"
+    "time"
 
     "security-technopark/internal/proxy"
 )
@@ -27,10 +30,80 @@ func getPort() string {
 }
 
 func run(port string) error {
-    if err := proxy.LoadCA("ca.crt", "ca.key"); err != nil {
+    if err := proxy.LoadOrCreateCA("ca.crt", "ca.key"); err != nil {
         return fmt.Errorf("ошибка загрузки CA: %w", err)
     }
 
+    if err := proxy.UseCertificateDirectory("certs", "cert.key"); err != nil {
+        return fmt.Errorf("ошибка подключения каталога сертификатов: %w", err)
+    }
+
+    if name := os.Getenv("CERT_KEY_TYPE"); name != "" {
+        keyType, err := proxy.ParseKeyType(name)
+        if err != nil {
+            return err
+        }
+        if err := proxy.SetCertificateKeyType(keyType); err != nil {
+            return fmt.Errorf("ошибка выбора типа ключа: %w", err)
+        }
+    }
+
+    historyDir := os.Getenv("HISTORY_DIR")
+    if historyDir == "" {
+        historyDir = "history"
+    }
+    if err := proxy.UseHistoryDirectory(historyDir); err != nil {
+        return fmt.Errorf("ошибка подключения истории запросов: %w", err)
+    }
+
+    if os.Getenv("MIRROR_UPSTREAM_CERTS") == "1" {
+        proxy.SetMirrorUpstreamCertificates(true)
+    }
+
+    if os.Getenv("WILDCARD_CERTS") == "1" {
+        proxy.SetWildcardCertificates(true)
+    }
+
+    if bundle := os.Getenv("UPSTREAM_CA_BUNDLE"); bundle != "" {
+        if err := proxy.LoadUpstreamCABundle(bundle); err != nil {
+            return fmt.Errorf("ошибка загрузки доверенных CA: %w", err)
+        }
+    }
+
+    if hosts := os.Getenv("UPSTREAM_INSECURE_HOSTS"); hosts != "" {
+        proxy.AllowInsecureUpstream(strings.Split(hosts, ",")...)
+    }
+
+    if limit := os.Getenv("MAX_BODY_SIZE"); limit != "" {
+        size, err := strconv.ParseInt(limit, 10, 64)
+        if err != nil {
+            return fmt.Errorf("некорректное значение MAX_BODY_SIZE: %w", err)
+        }
+        proxy.SetMaxBodySize(size)
+    }
+
+    if wait := os.Getenv("UPSTREAM_POOL_WAIT"); wait != "" {
+        timeout, err := time.ParseDuration(wait)
+        if err != nil {
+            return fmt.Errorf("некорректное значение UPSTREAM_POOL_WAIT: %w", err)
+        }
+        proxy.SetUpstreamPoolWaitTimeout(timeout)
+    }
+
+    if os.Getenv("FORWARDED_HEADERS") == "1" {
+        proxy.SetForwardedHeaders(true)
+    }
+
+    apiAddr := os.Getenv("API_ADDR")
+    if apiAddr == "" {
+        apiAddr = "127.0.0.1:8081"
+    }
+    go func() {
+        if err := proxy.StartManagementAPI(apiAddr); err != nil {
+            log.Printf("%v", err)
+        }
+    }()
+
     if err := proxy.StartProxy(port); err != nil {
         return fmt.Errorf("ошибка запуска прокси: %w", err)
     }
@@ -1,3 +1,9 @@
 module security-technopark
 
 go 1.23.4
+
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/net v0.38.0
+)
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+const (
+    defaultPageSize = 50
+    maxPageSize     = 500
+
+    maxRepeatEditsSize = 16 << 20
+)
+
+type requestPage struct {
+    Total  int              `json:"total"`
+    Offset int              `json:"offset"`
+    Limit  int              `json:"limit"`
+    Items  []HistorySummary `json:"items"`
+}
+
+type requestDetails struct {
+    ID           uint64          `json:"id"`
+    RepeatOf     uint64          `json:"repeat_of,omitempty"`
+    ConnectionID uint64          `json:"connection_id"`
+    ClientAddr   string          `json:"client_addr"`
+    StartedAt    time.Time       `json:"started_at"`
+    ResponseAt   time.Time       `json:"response_at"`
+    FinishedAt   time.Time       `json:"finished_at"`
+    Error        string          `json:"error,omitempty"`
+    TLS          *TLSDetails     `json:"tls,omitempty"`
+    Request      *ParsedRequest  `json:"request"`
+    Response     *ParsedResponse `json:"response,omitempty"`
+}
+
+func NewManagementHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /requests", handleListRequests)
+    mux.HandleFunc("GET /requests/{id}", handleGetRequest)
+    mux.HandleFunc("DELETE /requests/{id}", handleDeleteRequest)
+    mux.HandleFunc("POST /repeat/{id}", handleRepeatRequest)
+    return mux
+}
+
+func handleListRequests(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+
+    offset, err := queryInt(query.Get("offset"), 0)
+    if err != nil || offset < 0 {
+        writeAPIError(w, http.StatusBadRequest, fmt.Errorf("некорректный offset: %q", query.Get("offset")))
+        return
+    }
+    limit, err := queryInt(query.Get("limit"), defaultPageSize)
+    if err != nil || limit <= 0 {
+        writeAPIError(w, http.StatusBadRequest, fmt.Errorf("некорректный limit: %q", query.Get("limit")))
+        return
+    }
+    limit = min(limit, maxPageSize)
+
+    historyQuery, err := parseHistoryQuery(query)
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, err)
+        return
+    }
+    historyQuery.Offset, historyQuery.Limit = offset, limit
+
+    result, err := ListHistory(historyQuery)
+    if err != nil {
+        writeHistoryError(w, err)
+        return
+    }
+
+    page := requestPage{Total: result.Total, Offset: offset, Limit: limit, Items: result.Items}
+    writeJSON(w, http.StatusOK, page)
+}
+
+func handleGetRequest(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, fmt.Errorf("некорректный идентификатор: %q", r.PathValue("id")))
+        return
+    }
+
+    entry, err := GetHistoryEntry(id)
+    if err != nil {
+        writeHistoryError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, requestDetails{
+        ID:           entry.ID,
+        RepeatOf:     entry.RepeatOf,
+        ConnectionID: entry.ConnectionID,
+        ClientAddr:   entry.ClientAddr,
+        StartedAt:    entry.StartedAt,
+        ResponseAt:   entry.ResponseAt,
+        FinishedAt:   entry.FinishedAt,
+        Error:        entry.Error,
+        TLS:          entry.TLS,
+        Request:      entry.ParsedRequest(),
+        Response:     entry.ParsedResponse(),
+    })
+}
+
+func handleDeleteRequest(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, fmt.Errorf("некорректный идентификатор: %q", r.PathValue("id")))
+        return
+    }
+
+    if err := DeleteHistoryEntry(id); err != nil {
+        writeHistoryError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func handleRepeatRequest(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, fmt.Errorf("некорректный идентификатор: %q", r.PathValue("id")))
+        return
+    }
+
+    edits := RepeatEdits{}
+    if r.ContentLength != 0 {
+        decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRepeatEditsSize))
+        decoder.DisallowUnknownFields()
+        if err := decoder.Decode(&edits); err != nil && !errors.Is(err, io.EOF) {
+            writeAPIError(w, http.StatusBadRequest, fmt.Errorf("%w: %v", errInvalidRepeatEdit, err))
+            return
+        }
+    }
+
+    result, err := RepeatRequest(id, edits)
+    switch {
+    case errors.Is(err, errInvalidRepeatEdit):
+        writeAPIError(w, http.StatusBadRequest, err)
+    case errors.Is(err, errTruncatedRequest):
+        writeAPIError(w, http.StatusConflict, err)
+    case err != nil:
+        writeHistoryError(w, err)
+    case result.Error != "":
+        writeJSON(w, http.StatusBadGateway, result)
+    default:
+        writeJSON(w, http.StatusOK, result)
+    }
+}
+
+func parseHistoryQuery(query url.Values) (HistoryQuery, error) {
+    historyQuery := HistoryQuery{
+        Method:     query.Get("method"),
+        Scheme:     query.Get("scheme"),
+        Host:       query.Get("host"),
+        Search:     query.Get("q"),
+        BodySearch: query.Get("body"),
+    }
+
+    var err error
+    if historyQuery.StatusCode, err = queryInt(query.Get("status"), 0); err != nil {
+        return historyQuery, fmt.Errorf("некорректный status: %q", query.Get("status"))
+    }
+    if historyQuery.Since, err = queryTime(query.Get("since")); err != nil {
+        return historyQuery, fmt.Errorf("некорректный since: %w", err)
+    }
+    if historyQuery.Until, err = queryTime(query.Get("until")); err != nil {
+        return historyQuery, fmt.Errorf("некорректный until: %w", err)
+    }
+    return historyQuery, nil
+}
+
+func queryInt(value string, fallback int) (int, error) {
+    if value == "" {
+        return fallback, nil
+    }
+    return strconv.Atoi(value)
+}
+
+func queryTime(value string) (time.Time, error) {
+    if value == "" {
+        return time.Time{}, nil
+    }
+    return time.Parse(time.RFC3339, value)
+}
+
+func writeHistoryError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, ErrHistoryNotFound):
+        writeAPIError(w, http.StatusNotFound, err)
+    case errors.Is(err, errHistoryDisabled):
+        writeAPIError(w, http.StatusServiceUnavailable, err)
+    default:
+        writeAPIError(w, http.StatusInternalServerError, err)
+    }
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+    writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(value)
+}
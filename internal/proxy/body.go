@@ -0,0 +1,206 @@
+package proxy
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "sync/atomic"
+)
+
+const clientReaderSize = 64 << 10
+
+var (
+    errBodyTooLarge     = errors.New("тело запроса превышает допустимый размер")
+    errHeaderTooLarge   = errors.New("заголовки запроса превышают допустимый размер")
+    errAmbiguousFraming = errors.New("неоднозначная длина тела запроса")
+    maxRequestBodySize  int64
+)
+
+type limitedBody struct {
+    body      io.ReadCloser
+    remaining int64
+    exceeded  bool
+}
+
+func SetMaxBodySize(limit int64) {
+    atomic.StoreInt64(&maxRequestBodySize, limit)
+}
+
+func MaxBodySize() int64 {
+    return atomic.LoadInt64(&maxRequestBodySize)
+}
+
+func enforceBodyLimit(request *http.Request) error {
+    limit := MaxBodySize()
+    if limit <= 0 || request.Body == nil || request.Body == http.NoBody {
+        return nil
+    }
+
+    if request.ContentLength > limit {
+        return fmt.Errorf("%w: Content-Length %d > %d", errBodyTooLarge, request.ContentLength, limit)
+    }
+
+    request.Body = &limitedBody{body: request.Body, remaining: limit}
+    return nil
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+    if b.remaining <= 0 {
+        var probe [1]byte
+        if n, _ := b.body.Read(probe[:]); n > 0 {
+            b.exceeded = true
+            return 0, errBodyTooLarge
+        }
+        return 0, io.EOF
+    }
+
+    if int64(len(p)) > b.remaining {
+        p = p[:b.remaining]
+    }
+    n, err := b.body.Read(p)
+    b.remaining -= int64(n)
+    return n, err
+}
+
+func bodyLimitExceeded(request *http.Request) bool {
+    body := request.Body
+    if capturing, ok := body.(*capturingBody); ok {
+        body = capturing.body
+    }
+    limited, ok := body.(*limitedBody)
+    return ok && limited.exceeded
+}
+
+func (b *limitedBody) Close() error {
+    return b.body.Close()
+}
+
+func peekHeaderBlock(reader *bufio.Reader) ([]byte, error) {
+    if _, err := reader.Peek(1); err != nil {
+        return nil, err
+    }
+
+    for {
+        data, _ := reader.Peek(reader.Buffered())
+        if end := headerBlockEnd(data); end >= 0 {
+            return data[:end], nil
+        }
+
+        if reader.Buffered() >= reader.Size() {
+            return nil, errHeaderTooLarge
+        }
+        if _, err := reader.Peek(reader.Buffered() + 1); err != nil {
+            return nil, err
+        }
+    }
+}
+
+func headerBlockEnd(data []byte) int {
+    for i := 0; i < len(data); i++ {
+        if data[i] != '\n' {
+            continue
+        }
+        if i+1 < len(data) && data[i+1] == '\n' {
+            return i + 2
+        }
+        if i+2 < len(data) && data[i+1] == '\r' && data[i+2] == '\n' {
+            return i + 3
+        }
+    }
+    return -1
+}
+
+func checkRequestFraming(rawHeader []byte) error {
+    lines := strings.Split(string(rawHeader), "\n")
+    requestLine := strings.TrimSuffix(lines[0], "\r")
+    contentLengths, transferEncodings := 0, 0
+
+    for _, line := range lines[1:] {
+        line = strings.TrimSuffix(line, "\r")
+        if line == "" {
+            continue
+        }
+        if line[0] == ' ' || line[0] == '\t' {
+            return fmt.Errorf("%w: перенос строки в заголовке (obs-fold)", errAmbiguousFraming)
+        }
+
+        name, _, found := strings.Cut(line, ":")
+        if !found {
+            continue
+        }
+        if strings.ContainsAny(name, " \t") {
+            return fmt.Errorf("%w: пробел в имени заголовка %q", errAmbiguousFraming, name)
+        }
+
+        switch strings.ToLower(name) {
+        case "content-length":
+            contentLengths++
+        case "transfer-encoding":
+            transferEncodings++
+        }
+    }
+
+    if contentLengths > 1 {
+        return fmt.Errorf("%w: повторяющийся Content-Length", errAmbiguousFraming)
+    }
+    if contentLengths > 0 && transferEncodings > 0 {
+        return fmt.Errorf("%w: одновременно Content-Length и Transfer-Encoding", errAmbiguousFraming)
+    }
+    if transferEncodings > 0 && strings.HasSuffix(requestLine, "HTTP/1.0") {
+        return fmt.Errorf("%w: Transfer-Encoding в запросе HTTP/1.0", errAmbiguousFraming)
+    }
+    return nil
+}
+
+const defaultCaptureLimit = 1 << 20
+
+var captureLimit int64 = defaultCaptureLimit
+
+type CapturedBody struct {
+    Data      []byte `json:"data,omitempty"`
+    Size      int64  `json:"size"`
+    Truncated bool   `json:"truncated,omitempty"`
+
+    Encoding         string `json:"encoding,omitempty"`
+    Decoded          []byte `json:"decoded,omitempty"`
+    DecodedTruncated bool   `json:"decoded_truncated,omitempty"`
+    DecodeError      string `json:"decode_error,omitempty"`
+}
+
+type capturingBody struct {
+    body    io.ReadCloser
+    limit   int64
+    capture *CapturedBody
+}
+
+func SetCaptureLimit(limit int64) {
+    atomic.StoreInt64(&captureLimit, limit)
+}
+
+func captureBody(body io.ReadCloser, capture *CapturedBody) io.ReadCloser {
+    if body == nil || body == http.NoBody {
+        return body
+    }
+    return &capturingBody{body: body, limit: atomic.LoadInt64(&captureLimit), capture: capture}
+}
+
+func (b *capturingBody) Read(p []byte) (int, error) {
+    n, err := b.body.Read(p)
+    if n > 0 {
+        b.capture.Size += int64(n)
+        if room := b.limit - int64(len(b.capture.Data)); room > 0 {
+            b.capture.Data = append(b.capture.Data, p[:min(int64(n), room)]...)
+        }
+        if b.capture.Size > int64(len(b.capture.Data)) {
+            b.capture.Truncated = true
+        }
+    }
+    return n, err
+}
+
+func (b *capturingBody) Close() error {
+    return b.body.Close()
+}
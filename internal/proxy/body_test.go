@@ -0,0 +1,194 @@
+package proxy
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "strings"
+    "testing"
+)
+
+func readTestRequest(raw string) (*clientSession, error) {
+    session := newClientSession(nextConnectionID(), nil, bufio.NewReader(strings.NewReader(raw)))
+    _, _, err := session.readRequest()
+    return session, err
+}
+
+func TestReadRequestFraming(t *testing.T) {
+    tests := []struct {
+        name    string
+        raw     string
+        wantErr error
+    }{
+        {
+            name: "content-length",
+            raw:  "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello",
+        },
+        {
+            name: "chunked",
+            raw:  "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+        },
+        {
+            name:    "content-length и transfer-encoding",
+            raw:     "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "transfer-encoding и content-length в другом регистре",
+            raw:     "POST / HTTP/1.1\r\nHost: a\r\ntransfer-encoding: chunked\r\ncontent-length: 5\r\n\r\n0\r\n\r\n",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "одинаковые content-length",
+            raw:     "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "разные content-length",
+            raw:     "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "transfer-encoding в HTTP/1.0",
+            raw:     "POST / HTTP/1.0\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "obs-fold",
+            raw:     "GET / HTTP/1.1\r\nHost: a\r\nX-Long: first\r\n second\r\n\r\n",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "obs-fold табуляцией",
+            raw:     "GET / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: identity\r\n\tchunked\r\n\r\n",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "пробел перед двоеточием",
+            raw:     "POST / HTTP/1.1\r\nHost: a\r\nContent-Length : 5\r\n\r\nhello",
+            wantErr: errAmbiguousFraming,
+        },
+        {
+            name:    "табуляция перед двоеточием",
+            raw:     "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding\t: chunked\r\n\r\n0\r\n\r\n",
+            wantErr: errAmbiguousFraming,
+        },
+    }
+
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            _, err := readTestRequest(tc.raw)
+            switch {
+            case tc.wantErr == nil && err != nil:
+                t.Fatalf("неожиданная ошибка: %v", err)
+            case tc.wantErr != nil && !errors.Is(err, tc.wantErr):
+                t.Fatalf("ошибка = %v, ожидалась %v", err, tc.wantErr)
+            }
+        })
+    }
+}
+
+func TestEnforceBodyLimit(t *testing.T) {
+    previous := MaxBodySize()
+    SetMaxBodySize(16)
+    t.Cleanup(func() { SetMaxBodySize(previous) })
+
+    tests := []struct {
+        name       string
+        raw        string
+        wantReject bool
+        wantBody   string
+        wantTooBig bool
+    }{
+        {
+            name:     "content-length в пределах лимита",
+            raw:      "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 16\r\n\r\n0123456789abcdef",
+            wantBody: "0123456789abcdef",
+        },
+        {
+            name:       "content-length больше лимита",
+            raw:        "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 17\r\n\r\n0123456789abcdefg",
+            wantReject: true,
+        },
+        {
+            name:     "chunked ровно на лимите",
+            raw:      "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n8\r\n01234567\r\n8\r\n89abcdef\r\n0\r\n\r\n",
+            wantBody: "0123456789abcdef",
+        },
+        {
+            name:       "chunked больше лимита",
+            raw:        "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n10\r\n0123456789abcdef\r\n1\r\nX\r\n0\r\n\r\n",
+            wantTooBig: true,
+        },
+    }
+
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            session := newClientSession(nextConnectionID(), nil, bufio.NewReader(strings.NewReader(tc.raw)))
+            request, _, err := session.readRequest()
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            err = enforceBodyLimit(request)
+            if tc.wantReject {
+                if !errors.Is(err, errBodyTooLarge) {
+                    t.Fatalf("ошибка = %v, ожидалась %v", err, errBodyTooLarge)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            body, err := io.ReadAll(request.Body)
+            if tc.wantTooBig {
+                if !errors.Is(err, errBodyTooLarge) || !bodyLimitExceeded(request) {
+                    t.Fatalf("ошибка = %v, ожидалось превышение лимита", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if string(body) != tc.wantBody {
+                t.Fatalf("тело = %q, ожидалось %q", body, tc.wantBody)
+            }
+        })
+    }
+}
+
+func TestPipelinedRequestAfterChunkedBody(t *testing.T) {
+    raw := "POST /first HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n" +
+        "5\r\nhello\r\n0\r\nX-Trailer: t\r\n\r\n" +
+        "GET /second HTTP/1.1\r\nHost: a\r\n\r\n" +
+        "POST /third HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc"
+
+    session := newClientSession(nextConnectionID(), nil, bufio.NewReader(strings.NewReader(raw)))
+
+    want := []struct {
+        target string
+        body   string
+    }{
+        {"/first", "hello"},
+        {"/second", ""},
+        {"/third", "abc"},
+    }
+    for _, expected := range want {
+        request, _, err := session.readRequest()
+        if err != nil {
+            t.Fatalf("%s: %v", expected.target, err)
+        }
+        body, err := io.ReadAll(request.Body)
+        if err != nil {
+            t.Fatalf("%s: %v", expected.target, err)
+        }
+        if request.RequestURI != expected.target || string(body) != expected.body {
+            t.Fatalf("получен %s %q, ожидался %s %q", request.RequestURI, body, expected.target, expected.body)
+        }
+    }
+
+    if _, _, err := session.readRequest(); !errors.Is(err, io.EOF) {
+        t.Fatalf("после последнего запроса ожидался EOF, получено %v", err)
+    }
+}
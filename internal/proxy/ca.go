@@ -1,20 +1,38 @@
 package proxy
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math/big"
 	"os"
+	"strings"
+	"time"
 )
 
 type CertificateManager struct {
 	rootCertificate *x509.Certificate
-	privateKey      *rsa.PrivateKey
+	privateKey      crypto.Signer
 }
 
 var certManager = &CertificateManager{}
 
+const (
+	rootCommonName   = "yngwie proxy CA"
+	rootKeySize      = 2048
+	rootValidityDays = 3650
+)
+
 func InitializeCertificateAuthority(certPath, keyPath string) error {
 	certData, keyData, err := loadCertificateFiles(certPath, keyPath)
 	if err != nil {
@@ -25,6 +43,7 @@ func InitializeCertificateAuthority(certPath, keyPath string) error {
 		return fmt.Errorf("ошибка обработки данных сертификатов: %w", err)
 	}
 
+	defaultStore.useAuthority(certManager)
 	return nil
 }
 
@@ -66,6 +85,11 @@ func (cm *CertificateManager) processCertificateData(certData, keyData []byte) e
 		return err
 	}
 
+	public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(cert.PublicKey) {
+		return fmt.Errorf("приватный ключ не соответствует корневому сертификату")
+	}
+
 	cm.rootCertificate = cert
 	cm.privateKey = key
 	return nil
@@ -89,28 +113,198 @@ func (cm *CertificateManager) parseCertificateBlock(data []byte) (*x509.Certific
 	return cert, nil
 }
 
-func (cm *CertificateManager) parsePrivateKeyBlock(data []byte) (*rsa.PrivateKey, error) {
+func (cm *CertificateManager) parsePrivateKeyBlock(data []byte) (crypto.Signer, error) {
 	block, rest := pem.Decode(data)
-	if block == nil || len(rest) > 0 {
+	if block != nil && block.Type == "EC PARAMETERS" {
+		block, rest = pem.Decode(rest)
+	}
+	if block == nil || len(strings.TrimSpace(string(rest))) > 0 {
 		return nil, fmt.Errorf("некорректный формат PEM ключа")
 	}
 
-	if block.Type != "RSA PRIVATE KEY" {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка парсинга приватного ключа PKCS#1: %w", err)
+		}
+		return key, nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка парсинга приватного ключа SEC1: %w", err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		return parsePKCS8Signer(block.Bytes)
+	default:
 		return nil, fmt.Errorf("неверный тип блока PEM: %s", block.Type)
 	}
+}
 
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+func parsePKCS8Signer(der []byte) (crypto.Signer, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка парсинга приватного ключа: %w", err)
+		return nil, fmt.Errorf("ошибка парсинга приватного ключа PKCS#8: %w", err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("неподдерживаемый тип ключа PKCS#8: %T", parsed)
 	}
+}
 
-	return key, nil
+func signatureAlgorithmFor(key crypto.Signer) (x509.SignatureAlgorithm, error) {
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, nil
+	case *ecdsa.PublicKey:
+		switch public.Curve {
+		case elliptic.P256():
+			return x509.ECDSAWithSHA256, nil
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384, nil
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512, nil
+		}
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("неподдерживаемая кривая ECDSA: %s", public.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("неподдерживаемый тип ключа: %T", public)
+	}
 }
 
-func GetCertificateAndKey() (*x509.Certificate, *rsa.PrivateKey) {
+func GetCertificateAndKey() (*x509.Certificate, crypto.Signer) {
 	return certManager.rootCertificate, certManager.privateKey
 }
 
 func LoadCA(certPath, keyPath string) error {
 	return InitializeCertificateAuthority(certPath, keyPath)
 }
+
+func LoadOrCreateCA(certPath, keyPath string) error {
+	certExists, err := fileExists(certPath)
+	if err != nil {
+		return err
+	}
+	keyExists, err := fileExists(keyPath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case certExists && keyExists:
+		return InitializeCertificateAuthority(certPath, keyPath)
+	case certExists != keyExists:
+		return fmt.Errorf("найден только один из файлов CA (%s, %s): удалите его или восстановите пару", certPath, keyPath)
+	}
+
+	certData, keyData, err := generateRootCertificate()
+	if err != nil {
+		return fmt.Errorf("ошибка генерации корневого сертификата: %w", err)
+	}
+
+	if err := writeSecureFile(keyPath, keyData, 0o600); err != nil {
+		return err
+	}
+	if err := writeSecureFile(certPath, certData, 0o644); err != nil {
+		os.Remove(keyPath)
+		return err
+	}
+
+	if err := InitializeCertificateAuthority(certPath, keyPath); err != nil {
+		return err
+	}
+
+	printInstallHints(certPath)
+	return nil
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, fmt.Errorf("ошибка проверки файла %s: %w", path, err)
+}
+
+func writeSecureFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла %s: %w", path, err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("ошибка записи файла %s: %w", path, err)
+	}
+
+	return file.Close()
+}
+
+func generateRootCertificate() ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rootKeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка генерации RSA ключа: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка генерации серийного номера: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   rootCommonName,
+			Organization: []string{"MITM Security Proxy"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(rootValidityDays * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка создания сертификата: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func printInstallHints(certPath string) {
+	fmt.Printf("Создан новый корневой сертификат: %s\n", certPath)
+	fmt.Printf("SHA-256 отпечаток: %s\n", certificateFingerprint(certManager.rootCertificate))
+	fmt.Println("Добавьте его в доверенные, чтобы клиенты принимали перехваченные соединения:")
+	fmt.Printf("  Debian/Ubuntu: cp %s /usr/local/share/ca-certificates/mitm-proxy.crt && update-ca-certificates\n", certPath)
+	fmt.Printf("  Alpine:        cp %s /usr/local/share/ca-certificates/ && update-ca-certificates\n", certPath)
+	fmt.Printf("  macOS:         security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %s\n", certPath)
+	fmt.Printf("  curl:          curl --cacert %s -x http://127.0.0.1:<порт> https://example.com\n", certPath)
+	fmt.Println("  Firefox:       Настройки → Сертификаты → Импорт")
+}
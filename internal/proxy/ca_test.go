@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func selfSignedAuthority(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: rootCommonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour * 365),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("создание CA: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodePKCS8(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAuthorityKeyFormats(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       func(t *testing.T) (crypto.Signer, []byte)
+		algorithm x509.SignatureAlgorithm
+	}{
+		{
+			name: "PKCS#8 ECDSA P-256",
+			key: func(t *testing.T) (crypto.Signer, []byte) {
+				key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				return key, encodePKCS8(t, key)
+			},
+			algorithm: x509.ECDSAWithSHA256,
+		},
+		{
+			name: "SEC1 с блоком EC PARAMETERS",
+			key: func(t *testing.T) (crypto.Signer, []byte) {
+				key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+				der, err := x509.MarshalECPrivateKey(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				params, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 34})
+				data := pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: params})
+				return key, append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+			},
+			algorithm: x509.ECDSAWithSHA384,
+		},
+		{
+			name: "PKCS#8 Ed25519",
+			key: func(t *testing.T) (crypto.Signer, []byte) {
+				_, key, _ := ed25519.GenerateKey(rand.Reader)
+				return key, encodePKCS8(t, key)
+			},
+			algorithm: x509.PureEd25519,
+		},
+		{
+			name: "PKCS#1 RSA",
+			key: func(t *testing.T) (crypto.Signer, []byte) {
+				key, _ := rsa.GenerateKey(rand.Reader, 2048)
+				return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+			},
+			algorithm: x509.SHA256WithRSA,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, keyPEM := test.key(t)
+			manager := &CertificateManager{}
+			if err := manager.processCertificateData(selfSignedAuthority(t, key), keyPEM); err != nil {
+				t.Fatalf("processCertificateData: %v", err)
+			}
+
+			generator := newTestGenerator(t, manager, KeyTypeECDSA)
+			cert, err := generator.generateCertificate("example.com")
+			if err != nil {
+				t.Fatalf("generateCertificate: %v", err)
+			}
+
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leaf.SignatureAlgorithm != test.algorithm {
+				t.Errorf("SignatureAlgorithm = %s, ожидался %s", leaf.SignatureAlgorithm, test.algorithm)
+			}
+
+			roots := x509.NewCertPool()
+			roots.AddCert(manager.rootCertificate)
+			if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err != nil {
+				t.Errorf("лист не проверяется подписавшим CA: %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthorityKeyRejected(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certPEM := selfSignedAuthority(t, key)
+
+	tests := []struct {
+		name   string
+		keyPEM []byte
+	}{
+		{name: "ключ от другого сертификата", keyPEM: encodePKCS8(t, other)},
+		{name: "неизвестный тип блока", keyPEM: pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: []byte{0}})},
+		{name: "лишние данные после ключа", keyPEM: append(encodePKCS8(t, key), encodePKCS8(t, other)...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := &CertificateManager{}
+			if err := manager.processCertificateData(certPEM, test.keyPEM); err == nil {
+				t.Error("ожидалась ошибка загрузки CA")
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+)
+
+type CapturedExchange struct {
+    ConnectionID   uint64       `json:"connection_id"`
+    ClientAddr     string       `json:"client_addr"`
+    Scheme         string       `json:"scheme"`
+    Host           string       `json:"host"`
+    Method         string       `json:"method"`
+    Target         string       `json:"target"`
+    Proto          string       `json:"proto"`
+    RequestHeader  http.Header  `json:"request_header"`
+    RequestFields  HeaderList   `json:"request_fields"`
+    RequestBody    CapturedBody `json:"request_body"`
+    StatusCode     int          `json:"status_code"`
+    Status         string       `json:"status"`
+    ResponseProto  string       `json:"response_proto,omitempty"`
+    ResponseHeader http.Header  `json:"response_header,omitempty"`
+    ResponseFields HeaderList   `json:"response_fields,omitempty"`
+    ResponseBody   CapturedBody `json:"response_body"`
+    Error          string       `json:"error,omitempty"`
+    StartedAt      time.Time    `json:"started_at"`
+    ResponseAt     time.Time    `json:"response_at"`
+    FinishedAt     time.Time    `json:"finished_at"`
+    TLS            *TLSDetails  `json:"tls,omitempty"`
+}
+
+type CaptureHook func(*CapturedExchange)
+
+var (
+    captureMutex sync.RWMutex
+    captureHooks = []CaptureHook{logExchange, recordHistory}
+)
+
+func AddCaptureHook(hook CaptureHook) {
+    captureMutex.Lock()
+    defer captureMutex.Unlock()
+    captureHooks = append(captureHooks, hook)
+}
+
+func newCapturedExchange(connectionID uint64, clientConn net.Conn, scheme, host string) *CapturedExchange {
+    return &CapturedExchange{
+        ConnectionID: connectionID,
+        ClientAddr:   clientConn.RemoteAddr().String(),
+        Scheme:       scheme,
+        Host:         host,
+        StartedAt:    time.Now(),
+    }
+}
+
+func captureExchange(exchange *CapturedExchange, err error) {
+    finishExchange(exchange, err)
+
+    captureMutex.RLock()
+    hooks := captureHooks
+    captureMutex.RUnlock()
+
+    for _, hook := range hooks {
+        hook(exchange)
+    }
+}
+
+func finishExchange(exchange *CapturedExchange, err error) {
+    exchange.FinishedAt = time.Now()
+    if err != nil {
+        exchange.Error = err.Error()
+    }
+    exchange.RequestBody.decode(exchange.RequestHeader)
+    exchange.ResponseBody.decode(exchange.ResponseHeader)
+}
+
+func logExchange(exchange *CapturedExchange) {
+    fmt.Printf("%s %s %s://%s%s -> %d (%s)\n",
+        exchange.ClientAddr, exchange.Method, exchange.Scheme, exchange.Host, exchange.Target,
+        exchange.StatusCode, exchange.FinishedAt.Sub(exchange.StartedAt).Round(time.Millisecond))
+}
+
+func (e *CapturedExchange) recordRequest(request *http.Request, fields HeaderList) {
+    e.Method = request.Method
+    e.Target = request.RequestURI
+    e.Proto = request.Proto
+    e.RequestHeader = request.Header.Clone()
+    if request.Host != "" {
+        e.RequestHeader.Set("Host", request.Host)
+    }
+    e.RequestFields = fields
+}
+
+func (e *CapturedExchange) recordResponse(response *http.Response, fields HeaderList) {
+    e.ResponseAt = time.Now()
+    e.StatusCode = response.StatusCode
+    e.Status = response.Status
+    e.ResponseProto = response.Proto
+    e.ResponseHeader = response.Header.Clone()
+    e.ResponseFields = fields
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+    "container/list"
+    "crypto/tls"
+    "crypto/x509"
+    "sync"
+    "time"
+)
+
+const (
+    defaultCacheCapacity = 1024
+    defaultRenewBefore   = 24 * time.Hour
+)
+
+type CacheStats struct {
+    Hits      uint64
+    Misses    uint64
+    Evictions uint64
+    Size      int
+    Capacity  int
+}
+
+type certificateCache struct {
+    mutex       sync.Mutex
+    capacity    int
+    renewBefore time.Duration
+    entries     map[string]*list.Element
+    order       *list.List
+    hits        uint64
+    misses      uint64
+    evictions   uint64
+}
+
+type cacheEntry struct {
+    hostname string
+    cert     *tls.Certificate
+    notAfter time.Time
+}
+
+func newCertificateCache(capacity int, renewBefore time.Duration) *certificateCache {
+    if capacity <= 0 {
+        capacity = defaultCacheCapacity
+    }
+    return &certificateCache{
+        capacity:    capacity,
+        renewBefore: renewBefore,
+        entries:     make(map[string]*list.Element),
+        order:       list.New(),
+    }
+}
+
+func (c *certificateCache) get(hostname string) (*tls.Certificate, bool) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    cert, exists := c.lookup(hostname)
+    if exists {
+        c.hits++
+    } else {
+        c.misses++
+    }
+    return cert, exists
+}
+
+func (c *certificateCache) peek(hostname string) (*tls.Certificate, bool) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.lookup(hostname)
+}
+
+func (c *certificateCache) lookup(hostname string) (*tls.Certificate, bool) {
+    element, exists := c.entries[hostname]
+    if !exists {
+        return nil, false
+    }
+
+    entry := element.Value.(*cacheEntry)
+    if c.needsRenewal(entry, time.Now()) {
+        c.removeElement(element)
+        c.evictions++
+        return nil, false
+    }
+
+    c.order.MoveToFront(element)
+    return entry.cert, true
+}
+
+func (c *certificateCache) put(hostname string, cert *tls.Certificate) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    entry := &cacheEntry{
+        hostname: hostname,
+        cert:     cert,
+        notAfter: certificateNotAfter(cert),
+    }
+
+    if element, exists := c.entries[hostname]; exists {
+        element.Value = entry
+        c.order.MoveToFront(element)
+        return
+    }
+
+    c.entries[hostname] = c.order.PushFront(entry)
+    c.evictOverflow()
+}
+
+func (c *certificateCache) evictOverflow() {
+    now := time.Now()
+    for element := c.order.Back(); element != nil && len(c.entries) > c.capacity; {
+        previous := element.Prev()
+        if c.needsRenewal(element.Value.(*cacheEntry), now) {
+            c.removeElement(element)
+            c.evictions++
+        }
+        element = previous
+    }
+
+    for len(c.entries) > c.capacity {
+        c.removeElement(c.order.Back())
+        c.evictions++
+    }
+}
+
+func (c *certificateCache) needsRenewal(entry *cacheEntry, now time.Time) bool {
+    return !entry.notAfter.IsZero() && now.Add(c.renewBefore).After(entry.notAfter)
+}
+
+func (c *certificateCache) removeElement(element *list.Element) {
+    c.order.Remove(element)
+    delete(c.entries, element.Value.(*cacheEntry).hostname)
+}
+
+func (c *certificateCache) purge() {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    c.entries = make(map[string]*list.Element)
+    c.order.Init()
+}
+
+func (c *certificateCache) resize(capacity int) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    if capacity <= 0 {
+        capacity = defaultCacheCapacity
+    }
+    c.capacity = capacity
+    c.evictOverflow()
+}
+
+func (c *certificateCache) stats() CacheStats {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    return CacheStats{
+        Hits:      c.hits,
+        Misses:    c.misses,
+        Evictions: c.evictions,
+        Size:      len(c.entries),
+        Capacity:  c.capacity,
+    }
+}
+
+func certificateNotAfter(cert *tls.Certificate) time.Time {
+    if cert.Leaf != nil {
+        return cert.Leaf.NotAfter
+    }
+    if len(cert.Certificate) == 0 {
+        return time.Time{}
+    }
+
+    leaf, err := x509.ParseCertificate(cert.Certificate[0])
+    if err != nil {
+        return time.Time{}
+    }
+    return leaf.NotAfter
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+var errStoredCertificateRejected = errors.New("отклонён сохранённый сертификат")
+
+type certificateDirectory struct {
+    path          string
+    sharedKeyPath string
+}
+
+func newCertificateDirectory(path, sharedKeyPath string) (*certificateDirectory, error) {
+    if err := os.MkdirAll(path, 0o700); err != nil {
+        return nil, fmt.Errorf("ошибка создания каталога сертификатов %s: %w", path, err)
+    }
+    return &certificateDirectory{path: path, sharedKeyPath: sharedKeyPath}, nil
+}
+
+func (d *certificateDirectory) filePaths(hostname string) (string, string, error) {
+    if hostname == "" || strings.ContainsAny(hostname, `/\`) || strings.Contains(hostname, "..") {
+        return "", "", fmt.Errorf("недопустимое имя хоста для файла: %q", hostname)
+    }
+
+    base := strings.ReplaceAll(hostname, "*", "_wildcard_")
+    base = strings.ReplaceAll(base, ":", "_")
+    return filepath.Join(d.path, base+".crt"), filepath.Join(d.path, base+".key"), nil
+}
+
+func (d *certificateDirectory) load(hostname string, root *x509.Certificate, minValidity time.Duration) (*tls.Certificate, error) {
+    certPath, keyPath, err := d.filePaths(hostname)
+    if err != nil {
+        return nil, err
+    }
+
+    certPEM, err := os.ReadFile(certPath)
+    if err != nil {
+        return nil, err
+    }
+
+    cert, err := d.loadKeyPair(certPEM, keyPath)
+    if err == nil {
+        err = verifyStoredIssuer(cert, root)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%w %s: %w", errStoredCertificateRejected, certPath, err)
+    }
+
+    if err := verifyStoredValidity(cert.Leaf, hostname, minValidity); err != nil {
+        return nil, fmt.Errorf("сертификат %s требует обновления: %w", certPath, err)
+    }
+    return cert, nil
+}
+
+func (d *certificateDirectory) loadKeyPair(certPEM []byte, keyPath string) (*tls.Certificate, error) {
+    keyPEM, err := os.ReadFile(keyPath)
+    if os.IsNotExist(err) && d.sharedKeyPath != "" {
+        keyPEM, err = os.ReadFile(d.sharedKeyPath)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    cert, err := tls.X509KeyPair(certPEM, keyPEM)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка чтения пары: %w", err)
+    }
+    return &cert, nil
+}
+
+func verifyStoredIssuer(cert *tls.Certificate, root *x509.Certificate) error {
+    if root == nil {
+        return fmt.Errorf("корневой сертификат не инициализирован")
+    }
+
+    leaf := cert.Leaf
+    if leaf == nil {
+        parsed, err := x509.ParseCertificate(cert.Certificate[0])
+        if err != nil {
+            return err
+        }
+        leaf = parsed
+        cert.Leaf = parsed
+    }
+
+    roots := x509.NewCertPool()
+    roots.AddCert(root)
+    if _, err := leaf.Verify(x509.VerifyOptions{
+        Roots:       roots,
+        CurrentTime: latest(leaf.NotBefore, root.NotBefore),
+        KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    }); err != nil {
+        return fmt.Errorf("не связан с текущим CA: %w", err)
+    }
+    return nil
+}
+
+func verifyStoredValidity(leaf *x509.Certificate, hostname string, minValidity time.Duration) error {
+    if time.Now().Add(minValidity).After(leaf.NotAfter) {
+        return fmt.Errorf("срок действия истекает %s", leaf.NotAfter.Format(time.RFC3339))
+    }
+    return leaf.VerifyHostname(hostname)
+}
+
+func latest(first, second time.Time) time.Time {
+    if first.After(second) {
+        return first
+    }
+    return second
+}
+
+func (d *certificateDirectory) save(hostname string, cert *tls.Certificate) error {
+    certPath, keyPath, err := d.filePaths(hostname)
+    if err != nil {
+        return err
+    }
+
+    keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+    if err != nil {
+        return fmt.Errorf("ошибка кодирования приватного ключа: %w", err)
+    }
+
+    var certPEM []byte
+    for _, der := range cert.Certificate {
+        certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+    }
+
+    if err := writeFileAtomically(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+        return err
+    }
+    return writeFileAtomically(certPath, certPEM, 0o644)
+}
+
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+    temp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+    if err != nil {
+        return fmt.Errorf("ошибка создания временного файла: %w", err)
+    }
+    defer os.Remove(temp.Name())
+
+    if _, err := temp.Write(data); err != nil {
+        temp.Close()
+        return fmt.Errorf("ошибка записи файла %s: %w", path, err)
+    }
+    if err := temp.Chmod(perm); err != nil {
+        temp.Close()
+        return err
+    }
+    if err := temp.Close(); err != nil {
+        return err
+    }
+
+    return os.Rename(temp.Name(), path)
+}
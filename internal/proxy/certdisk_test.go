@@ -0,0 +1,123 @@
+package proxy
+
+import (
+    "bytes"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestObtainCertificateKeepsRejectedFile(t *testing.T) {
+    generator := newTestGenerator(t, newTestAuthority(t), KeyTypeECDSA)
+    foreignGenerator := newTestGenerator(t, newTestAuthority(t), KeyTypeECDSA)
+
+    tests := []struct {
+        name     string
+        hostname string
+        write    func(t *testing.T, directory *certificateDirectory, hostname string)
+    }{
+        {name: "сертификат без ключа", hostname: "mail.ru", write: func(t *testing.T, directory *certificateDirectory, hostname string) {
+            foreignPEM, err := os.ReadFile(filepath.Join("..", "..", "certs", "mail.ru.crt"))
+            if err != nil {
+                t.Fatal(err)
+            }
+            certPath, _, _ := directory.filePaths(hostname)
+            if err := os.WriteFile(certPath, foreignPEM, 0o644); err != nil {
+                t.Fatal(err)
+            }
+        }},
+        {name: "сертификат другого CA", hostname: "example.com", write: func(t *testing.T, directory *certificateDirectory, hostname string) {
+            cert, err := foreignGenerator.generateCertificate(hostname)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := directory.save(hostname, cert); err != nil {
+                t.Fatal(err)
+            }
+        }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            directory, err := newCertificateDirectory(t.TempDir(), "")
+            if err != nil {
+                t.Fatal(err)
+            }
+            test.write(t, directory, test.hostname)
+
+            certPath, keyPath, _ := directory.filePaths(test.hostname)
+            certBefore, _ := os.ReadFile(certPath)
+            keyBefore, keyErr := os.ReadFile(keyPath)
+
+            store := NewCertificateStore()
+            if _, err := store.obtainCertificate(generator, directory, test.hostname); err != nil {
+                t.Fatalf("obtainCertificate: %v", err)
+            }
+
+            certAfter, _ := os.ReadFile(certPath)
+            if !bytes.Equal(certBefore, certAfter) {
+                t.Error("отклонённый сертификат перезаписан")
+            }
+            keyAfter, err := os.ReadFile(keyPath)
+            if os.IsNotExist(keyErr) != os.IsNotExist(err) || !bytes.Equal(keyBefore, keyAfter) {
+                t.Error("ключ отклонённого сертификата изменён")
+            }
+        })
+    }
+
+    directory, err := newCertificateDirectory(t.TempDir(), "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewCertificateStore().obtainCertificate(generator, directory, "example.com"); err != nil {
+        t.Fatalf("obtainCertificate: %v", err)
+    }
+    if _, err := directory.load("example.com", generator.rootCert, defaultRenewBefore); err != nil {
+        t.Errorf("новый сертификат не сохранён: %v", err)
+    }
+}
+
+func TestObtainCertificateRenewsOwnExpiredFile(t *testing.T) {
+    generator := newTestGenerator(t, newTestAuthority(t), KeyTypeECDSA)
+    directory, err := newCertificateDirectory(t.TempDir(), "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    template, err := generator.createCertificateTemplate("example.com")
+    if err != nil {
+        t.Fatal(err)
+    }
+    template.NotBefore = generator.rootCert.NotBefore
+    template.NotAfter = time.Now().Add(-time.Hour)
+    privateKey, err := generator.generatePrivateKey()
+    if err != nil {
+        t.Fatal(err)
+    }
+    expired, err := generator.createTLSCertificate(template, privateKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := directory.save("example.com", expired); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := directory.load("example.com", generator.rootCert, defaultRenewBefore); err == nil || errors.Is(err, errStoredCertificateRejected) {
+        t.Fatalf("истёкший собственный сертификат должен требовать обновления, получено %v", err)
+    }
+
+    store := NewCertificateStore()
+    if _, err := store.obtainCertificate(generator, directory, "example.com"); err != nil {
+        t.Fatalf("obtainCertificate: %v", err)
+    }
+
+    renewed, err := directory.load("example.com", generator.rootCert, defaultRenewBefore)
+    if err != nil {
+        t.Fatalf("сертификат на диске не обновлён: %v", err)
+    }
+    if !renewed.Leaf.NotAfter.After(time.Now()) {
+        t.Errorf("на диске остался истёкший сертификат: NotAfter = %s", renewed.Leaf.NotAfter)
+    }
+}
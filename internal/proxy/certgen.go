@@ -1,30 +1,47 @@
 package proxy
 
 import (
+    "crypto"
     "crypto/rand"
-    "crypto/rsa"
     "crypto/tls"
     "crypto/x509"
     "crypto/x509/pkix"
     "encoding/pem"
+    "errors"
     "fmt"
     "math/big"
+    "net"
+    "strings"
     "sync"
     "time"
+
+    "golang.org/x/net/publicsuffix"
 )
 
 type CertificateStore struct {
-    cache     map[string]*tls.Certificate
+    cache     *certificateCache
+    pending   map[string]*pendingCertificate
     mutex     sync.RWMutex
     generator *CertificateGenerator
+    directory *certificateDirectory
+}
+
+type pendingCertificate struct {
+    done chan struct{}
+    cert *tls.Certificate
+    err  error
 }
 
 type CertificateGenerator struct {
+    keyType       KeyType
     keySize       int
+    keyPool       *keyPool
     organization  string
     validityDays  int
+    wildcard      bool
+    mirror        bool
     rootCert      *x509.Certificate
-    rootKey       *rsa.PrivateKey
+    rootKey       crypto.Signer
 }
 
 type CertificateOptions struct {
@@ -39,13 +56,119 @@ var defaultStore = NewCertificateStore()
 
 func NewCertificateStore() *CertificateStore {
     return &CertificateStore{
-        cache: make(map[string]*tls.Certificate),
-        generator: &CertificateGenerator{
-            keySize:      2048,
-            organization: "MITM Security Proxy",
-            validityDays: 30,
-        },
+        cache:     newCertificateCache(defaultCacheCapacity, defaultRenewBefore),
+        pending:   make(map[string]*pendingCertificate),
+        generator: NewCertificateGenerator(KeyTypeECDSA),
+    }
+}
+
+func NewCertificateGenerator(keyType KeyType) *CertificateGenerator {
+    g := &CertificateGenerator{
+        keySize:      2048,
+        organization: "MITM Security Proxy",
+        validityDays: 30,
+    }
+    if err := g.SetKeyType(keyType); err != nil {
+        g.SetKeyType(KeyTypeECDSA)
+    }
+    return g
+}
+
+func (g *CertificateGenerator) SetKeyType(keyType KeyType) error {
+    generate, err := newKeyGenerator(keyType, g.keySize)
+    if err != nil {
+        return err
+    }
+
+    if g.keyPool != nil {
+        g.keyPool.shutdown()
+    }
+    g.keyType = keyType
+    g.keyPool = newKeyPool(defaultKeyPoolSize, generate)
+    return nil
+}
+
+func (g *CertificateGenerator) KeyType() KeyType {
+    return g.keyType
+}
+
+func (g *CertificateGenerator) clone() *CertificateGenerator {
+    next := *g
+    return &next
+}
+
+func (s *CertificateStore) SetKeyType(keyType KeyType) error {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    next := s.generator.clone()
+    if err := next.SetKeyType(keyType); err != nil {
+        return err
+    }
+    s.generator = next
+    s.cache.purge()
+    if s.generator.validateConfiguration() == nil {
+        s.generator.keyPool.warmUp()
+    }
+    return nil
+}
+
+func (s *CertificateStore) useAuthority(cm *CertificateManager) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    next := s.generator.clone()
+    next.rootCert = cm.rootCertificate
+    next.rootKey = cm.privateKey
+    s.generator = next
+    s.cache.purge()
+    s.generator.keyPool.warmUp()
+}
+
+func (s *CertificateStore) Ready() error {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    return s.generator.validateConfiguration()
+}
+
+func (s *CertificateStore) SetCacheCapacity(capacity int) {
+    s.cache.resize(capacity)
+}
+
+func (s *CertificateStore) Stats() CacheStats {
+    return s.cache.stats()
+}
+
+func (s *CertificateStore) UseDirectory(path, sharedKeyPath string) error {
+    directory, err := newCertificateDirectory(path, sharedKeyPath)
+    if err != nil {
+        return err
     }
+
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.directory = directory
+    return nil
+}
+
+func UseCertificateDirectory(path, sharedKeyPath string) error {
+    return defaultStore.UseDirectory(path, sharedKeyPath)
+}
+
+func SetCertificateKeyType(keyType KeyType) error {
+    return defaultStore.SetKeyType(keyType)
+}
+
+func SetMirrorUpstreamCertificates(enabled bool) {
+    defaultStore.SetMirrorUpstream(enabled)
+}
+
+func SetWildcardCertificates(enabled bool) {
+    defaultStore.SetWildcard(enabled)
+}
+
+func GetCertificateCacheStats() CacheStats {
+    return defaultStore.Stats()
 }
 
 func GetCertificate(hostname string) (*tls.Certificate, error) {
@@ -53,31 +176,109 @@ func GetCertificate(hostname string) (*tls.Certificate, error) {
 }
 
 func (s *CertificateStore) GetOrCreateCertificate(hostname string) (*tls.Certificate, error) {
+    return s.getOrCreate(hostname, "")
+}
+
+func (s *CertificateStore) getOrCreate(hostname, upstreamAddr string) (*tls.Certificate, error) {
     s.mutex.RLock()
-    cert, exists := s.cache[hostname]
+    name := s.generator.certificateName(hostname)
     s.mutex.RUnlock()
-    
-    if exists {
+
+    if cert, exists := s.cache.get(name); exists {
         return cert, nil
     }
 
-    return s.createAndStoreCertificate(hostname)
+    return s.createAndStoreCertificate(name, upstreamAddr)
+}
+
+func (s *CertificateStore) GetCertificateFunc(fallbackHost, upstreamAddr string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+        if hello.ServerName != "" {
+            return s.getOrCreate(hello.ServerName, upstreamAddr)
+        }
+        return s.getOrCreate(fallbackHost, upstreamAddr)
+    }
+}
+
+func (s *CertificateStore) SetMirrorUpstream(enabled bool) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    next := s.generator.clone()
+    next.mirror = enabled
+    s.generator = next
+    s.cache.purge()
 }
 
-func (s *CertificateStore) createAndStoreCertificate(hostname string) (*tls.Certificate, error) {
+func (s *CertificateStore) SetWildcard(enabled bool) {
     s.mutex.Lock()
     defer s.mutex.Unlock()
 
-    if cert, exists := s.cache[hostname]; exists {
+    next := s.generator.clone()
+    next.wildcard = enabled
+    s.generator = next
+    s.cache.purge()
+}
+
+func (s *CertificateStore) createAndStoreCertificate(hostname, upstreamAddr string) (*tls.Certificate, error) {
+    s.mutex.Lock()
+    if cert, exists := s.cache.peek(hostname); exists {
+        s.mutex.Unlock()
         return cert, nil
     }
 
-    cert, err := s.generator.generateCertificate(hostname)
+    if call, inFlight := s.pending[hostname]; inFlight {
+        s.mutex.Unlock()
+        <-call.done
+        return call.cert, call.err
+    }
+
+    call := &pendingCertificate{done: make(chan struct{})}
+    s.pending[hostname] = call
+    generator := s.generator
+    directory := s.directory
+    s.mutex.Unlock()
+
+    if generator.mirror && upstreamAddr != "" {
+        call.cert, call.err = s.obtainMirroredCertificate(generator, hostname, upstreamAddr)
+    } else {
+        call.cert, call.err = s.obtainCertificate(generator, directory, hostname)
+    }
+
+    s.mutex.Lock()
+    delete(s.pending, hostname)
+    if call.err == nil && s.generator == generator {
+        s.cache.put(hostname, call.cert)
+    }
+    s.mutex.Unlock()
+
+    close(call.done)
+    return call.cert, call.err
+}
+
+func (s *CertificateStore) obtainCertificate(generator *CertificateGenerator, directory *certificateDirectory, hostname string) (*tls.Certificate, error) {
+    if directory != nil {
+        cert, err := directory.load(hostname, generator.rootCert, s.cache.renewBefore)
+        if err == nil {
+            return cert, nil
+        }
+        if errors.Is(err, errStoredCertificateRejected) {
+            fmt.Printf("Предупреждение: %v; файл не будет перезаписан, удалите его для сохранения нового сертификата\n", err)
+            directory = nil
+        }
+    }
+
+    cert, err := generator.generateCertificate(hostname)
     if err != nil {
         return nil, fmt.Errorf("ошибка генерации сертификата: %w", err)
     }
 
-    s.cache[hostname] = cert
+    if directory != nil {
+        if err := directory.save(hostname, cert); err != nil {
+            fmt.Printf("Предупреждение: не удалось сохранить сертификат %s: %v\n", hostname, err)
+        }
+    }
+
     return cert, nil
 }
 
@@ -106,12 +307,30 @@ func (g *CertificateGenerator) validateConfiguration() error {
     return nil
 }
 
-func (g *CertificateGenerator) generatePrivateKey() (*rsa.PrivateKey, error) {
-    key, err := rsa.GenerateKey(rand.Reader, g.keySize)
-    if err != nil {
-        return nil, fmt.Errorf("ошибка генерации RSA ключа: %w", err)
+func (g *CertificateGenerator) generatePrivateKey() (crypto.Signer, error) {
+    return g.keyPool.get()
+}
+
+func (g *CertificateGenerator) certificateName(hostname string) string {
+    name := strings.TrimSuffix(strings.ToLower(hostname), ".")
+    if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+        return ip.String()
     }
-    return key, nil
+
+    if !g.wildcard || g.mirror {
+        return name
+    }
+
+    labels := strings.Split(name, ".")
+    if len(labels) < 3 || labels[0] == "*" {
+        return name
+    }
+
+    parent := strings.Join(labels[1:], ".")
+    if suffix, _ := publicsuffix.PublicSuffix(parent); suffix == parent {
+        return name
+    }
+    return "*." + parent
 }
 
 func (g *CertificateGenerator) createCertificateTemplate(hostname string) (*x509.Certificate, error) {
@@ -120,28 +339,48 @@ func (g *CertificateGenerator) createCertificateTemplate(hostname string) (*x509
         return nil, fmt.Errorf("ошибка генерации серийного номера: %w", err)
     }
 
+    signatureAlgorithm, err := signatureAlgorithmFor(g.rootKey)
+    if err != nil {
+        return nil, err
+    }
+
     now := time.Now()
-    return &x509.Certificate{
-        SerialNumber: serialNumber,
+    template := &x509.Certificate{
+        SerialNumber:       serialNumber,
+        SignatureAlgorithm: signatureAlgorithm,
         Subject: pkix.Name{
             CommonName:   hostname,
             Organization: []string{g.organization},
         },
         NotBefore:             now.Add(-time.Hour),
         NotAfter:              now.Add(time.Duration(g.validityDays) * 24 * time.Hour),
-        KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+        KeyUsage:              g.leafKeyUsage(),
         ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
         BasicConstraintsValid: true,
-        DNSNames:             []string{hostname},
-    }, nil
+    }
+
+    if ip := net.ParseIP(hostname); ip != nil {
+        template.IPAddresses = []net.IP{ip}
+    } else {
+        template.DNSNames = []string{hostname}
+    }
+
+    return template, nil
 }
 
-func (g *CertificateGenerator) createTLSCertificate(template *x509.Certificate, privateKey *rsa.PrivateKey) (*tls.Certificate, error) {
+func (g *CertificateGenerator) leafKeyUsage() x509.KeyUsage {
+    if g.keyType == KeyTypeRSA {
+        return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+    }
+    return x509.KeyUsageDigitalSignature
+}
+
+func (g *CertificateGenerator) createTLSCertificate(template *x509.Certificate, privateKey crypto.Signer) (*tls.Certificate, error) {
     certDER, err := x509.CreateCertificate(
         rand.Reader,
         template,
         g.rootCert,
-        &privateKey.PublicKey,
+        privateKey.Public(),
         g.rootKey,
     )
     if err != nil {
@@ -153,9 +392,14 @@ func (g *CertificateGenerator) createTLSCertificate(template *x509.Certificate,
         Bytes: certDER,
     })
 
+    keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка кодирования приватного ключа: %w", err)
+    }
+
     keyPEM := pem.EncodeToMemory(&pem.Block{
-        Type:  "RSA PRIVATE KEY",
-        Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+        Type:  "PRIVATE KEY",
+        Bytes: keyDER,
     })
 
     tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
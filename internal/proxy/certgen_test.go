@@ -0,0 +1,132 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "sync"
+    "testing"
+    "time"
+)
+
+func BenchmarkGenerateCertificate(b *testing.B) {
+    authority := newTestAuthority(b)
+
+    keyTypes := []struct {
+        name    string
+        keyType KeyType
+    }{
+        {"RSA", KeyTypeRSA},
+        {"ECDSA", KeyTypeECDSA},
+    }
+
+    for _, tc := range keyTypes {
+        b.Run(tc.name+"/cold", func(b *testing.B) {
+            generator := newTestGenerator(b, authority, tc.keyType)
+            generator.keyPool.start.Do(func() {})
+
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if _, err := generator.generateCertificate("bench.example.com"); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+
+        b.Run(tc.name+"/warm", func(b *testing.B) {
+            generator := newTestGenerator(b, authority, tc.keyType)
+            generator.keyPool.warmUp()
+
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                b.StopTimer()
+                for len(generator.keyPool.keys) == 0 {
+                    time.Sleep(time.Millisecond)
+                }
+                b.StartTimer()
+
+                if _, err := generator.generateCertificate("bench.example.com"); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+func TestGetOrCreateCertificateConcurrent(t *testing.T) {
+    store := NewCertificateStore()
+    store.useAuthority(newTestAuthority(t))
+    t.Cleanup(store.generator.keyPool.shutdown)
+
+    hostnames := []string{"a.example", "b.example", "c.example", "d.example", "e.example"}
+    const workers = 16
+
+    var (
+        wg      sync.WaitGroup
+        mutex   sync.Mutex
+        results = make(map[string]map[*tls.Certificate]int)
+    )
+    for worker := 0; worker < workers; worker++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < 20; i++ {
+                hostname := hostnames[(worker+i)%len(hostnames)]
+                cert, err := store.GetOrCreateCertificate(hostname)
+                if err != nil {
+                    t.Errorf("%s: %v", hostname, err)
+                    return
+                }
+
+                mutex.Lock()
+                if results[hostname] == nil {
+                    results[hostname] = make(map[*tls.Certificate]int)
+                }
+                results[hostname][cert]++
+                mutex.Unlock()
+            }
+        }()
+    }
+    wg.Wait()
+
+    for _, hostname := range hostnames {
+        if generated := len(results[hostname]); generated != 1 {
+            t.Errorf("%s: сгенерировано %d сертификатов, ожидался 1", hostname, generated)
+        }
+    }
+    if stats := store.Stats(); stats.Size != len(hostnames) {
+        t.Errorf("размер кэша = %d, ожидалось %d", stats.Size, len(hostnames))
+    }
+}
+
+func TestCertificateNameWildcard(t *testing.T) {
+    generator := NewCertificateGenerator(KeyTypeECDSA)
+    t.Cleanup(generator.keyPool.shutdown)
+    generator.wildcard = true
+
+    tests := []struct {
+        hostname string
+        want     string
+    }{
+        {"www.example.com", "*.example.com"},
+        {"a.b.example.com", "*.b.example.com"},
+        {"WWW.Example.COM.", "*.example.com"},
+        {"example.com", "example.com"},
+        {"foo.co.uk", "foo.co.uk"},
+        {"www.foo.co.uk", "*.foo.co.uk"},
+        {"user.github.io", "user.github.io"},
+        {"host.corp.internal", "*.corp.internal"},
+        {"*.example.com", "*.example.com"},
+        {"192.0.2.1", "192.0.2.1"},
+        {"[2001:db8::1]", "2001:db8::1"},
+    }
+
+    for _, tc := range tests {
+        if got := generator.certificateName(tc.hostname); got != tc.want {
+            t.Errorf("certificateName(%q) = %q, ожидалось %q", tc.hostname, got, tc.want)
+        }
+    }
+
+    generator.wildcard = false
+    if got := generator.certificateName("www.example.com"); got != "www.example.com" {
+        t.Errorf("без wildcard получено %q", got)
+    }
+}
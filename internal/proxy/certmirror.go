@@ -0,0 +1,104 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "net"
+    "time"
+)
+
+const (
+    upstreamProbeTimeout = 10 * time.Second
+    mirrorMinValidity    = 2 * defaultRenewBefore
+)
+
+func (s *CertificateStore) obtainMirroredCertificate(generator *CertificateGenerator, hostname, upstreamAddr string) (*tls.Certificate, error) {
+    upstream, err := fetchUpstreamCertificate(upstreamAddr, hostname)
+    if err != nil {
+        fmt.Printf("Предупреждение: не удалось получить сертификат %s, используется шаблонный: %v\n", upstreamAddr, err)
+        return s.obtainCertificate(generator, nil, hostname)
+    }
+
+    cert, err := generator.generateMirroredCertificate(hostname, upstream)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка генерации сертификата: %w", err)
+    }
+    return cert, nil
+}
+
+func fetchUpstreamCertificate(upstreamAddr, serverName string) (*x509.Certificate, error) {
+    if net.ParseIP(serverName) != nil {
+        serverName = ""
+    }
+
+    dialer := &net.Dialer{Timeout: upstreamProbeTimeout}
+    conn, err := tls.DialWithDialer(dialer, "tcp", upstreamAddr, &tls.Config{
+        InsecureSkipVerify: true,
+        ServerName:         serverName,
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+
+    peers := conn.ConnectionState().PeerCertificates
+    if len(peers) == 0 {
+        return nil, fmt.Errorf("сервер %s не предъявил сертификат", upstreamAddr)
+    }
+    return peers[0], nil
+}
+
+func (g *CertificateGenerator) generateMirroredCertificate(hostname string, upstream *x509.Certificate) (*tls.Certificate, error) {
+    if err := g.validateConfiguration(); err != nil {
+        return nil, err
+    }
+
+    privateKey, err := g.generatePrivateKey()
+    if err != nil {
+        return nil, err
+    }
+
+    template, err := g.createCertificateTemplate(hostname)
+    if err != nil {
+        return nil, err
+    }
+    mirrorCertificateFields(template, upstream, g.rootCert)
+
+    return g.createTLSCertificate(template, privateKey)
+}
+
+func mirrorCertificateFields(template, upstream, root *x509.Certificate) {
+    template.Subject = upstream.Subject
+    template.RawSubject = upstream.RawSubject
+    template.NotBefore, template.NotAfter = mirroredValidity(template, upstream, root)
+    template.ExtKeyUsage = upstream.ExtKeyUsage
+    template.UnknownExtKeyUsage = upstream.UnknownExtKeyUsage
+
+    if len(upstream.DNSNames)+len(upstream.IPAddresses)+len(upstream.URIs)+len(upstream.EmailAddresses) > 0 {
+        template.DNSNames = upstream.DNSNames
+        template.IPAddresses = upstream.IPAddresses
+        template.URIs = upstream.URIs
+        template.EmailAddresses = upstream.EmailAddresses
+    }
+}
+
+func mirroredValidity(template, upstream, root *x509.Certificate) (time.Time, time.Time) {
+    now := time.Now()
+    notBefore, notAfter := upstream.NotBefore, upstream.NotAfter
+
+    if notBefore.After(now) {
+        notBefore = template.NotBefore
+    }
+    if notAfter.Before(now.Add(mirrorMinValidity)) {
+        notAfter = template.NotAfter
+    }
+
+    if notBefore.Before(root.NotBefore) {
+        notBefore = root.NotBefore
+    }
+    if notAfter.After(root.NotAfter) {
+        notAfter = root.NotAfter
+    }
+    return notBefore, notAfter
+}
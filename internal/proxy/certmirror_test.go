@@ -0,0 +1,95 @@
+package proxy
+
+import (
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "testing"
+    "time"
+)
+
+func TestGenerateMirroredCertificate(t *testing.T) {
+    authority := newTestAuthority(t)
+    generator := newTestGenerator(t, authority, KeyTypeECDSA)
+    root := authority.rootCertificate
+    now := time.Now()
+
+    tests := []struct {
+        name          string
+        notBefore     time.Time
+        notAfter      time.Time
+        wantNotBefore time.Time
+        wantNotAfter  time.Time
+    }{
+        {
+            name:          "действующий сертификат",
+            notBefore:     root.NotBefore.Add(time.Minute),
+            notAfter:      now.Add(90 * 24 * time.Hour).Truncate(time.Second),
+            wantNotBefore: root.NotBefore.Add(time.Minute),
+            wantNotAfter:  now.Add(90 * 24 * time.Hour).Truncate(time.Second),
+        },
+        {
+            name:      "истёкший сертификат",
+            notBefore: now.Add(-400 * 24 * time.Hour),
+            notAfter:  now.Add(-24 * time.Hour),
+        },
+        {
+            name:      "истекает раньше окна обновления",
+            notBefore: now.Add(-24 * time.Hour),
+            notAfter:  now.Add(time.Hour),
+        },
+        {
+            name:          "дольше корневого CA",
+            notBefore:     root.NotBefore.Add(-time.Hour),
+            notAfter:      root.NotAfter.Add(365 * 24 * time.Hour),
+            wantNotBefore: root.NotBefore,
+            wantNotAfter:  root.NotAfter,
+        },
+    }
+
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            upstream := &x509.Certificate{
+                Subject:     pkix.Name{CommonName: "mirror.example", Organization: []string{"Upstream Org"}},
+                NotBefore:   tc.notBefore,
+                NotAfter:    tc.notAfter,
+                KeyUsage:    x509.KeyUsageKeyEncipherment,
+                ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+                DNSNames:    []string{"mirror.example", "www.mirror.example"},
+            }
+
+            cert, err := generator.generateMirroredCertificate("mirror.example", upstream)
+            if err != nil {
+                t.Fatal(err)
+            }
+            leaf, err := x509.ParseCertificate(cert.Certificate[0])
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if leaf.KeyUsage != x509.KeyUsageDigitalSignature {
+                t.Errorf("KeyUsage = %v, ожидался DigitalSignature для ECDSA ключа", leaf.KeyUsage)
+            }
+            if leaf.Subject.Organization[0] != "Upstream Org" || len(leaf.DNSNames) != 2 {
+                t.Errorf("поля upstream не скопированы: %v %v", leaf.Subject, leaf.DNSNames)
+            }
+
+            if !tc.wantNotAfter.IsZero() {
+                if !leaf.NotBefore.Equal(tc.wantNotBefore.UTC().Truncate(time.Second)) || !leaf.NotAfter.Equal(tc.wantNotAfter.UTC().Truncate(time.Second)) {
+                    t.Errorf("срок = %s..%s, ожидалось %s..%s", leaf.NotBefore, leaf.NotAfter, tc.wantNotBefore, tc.wantNotAfter)
+                }
+            }
+
+            roots := x509.NewCertPool()
+            roots.AddCert(root)
+            if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "mirror.example", Roots: roots}); err != nil {
+                t.Errorf("лист не проверяется цепочкой CA: %v", err)
+            }
+
+            cache := newCertificateCache(1, defaultRenewBefore)
+            cache.put("mirror.example", cert)
+            if _, ok := cache.get("mirror.example"); !ok {
+                t.Error("сертификат сразу вытесняется из кэша как требующий обновления")
+            }
+        })
+    }
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+    "bytes"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "sync/atomic"
+
+    "github.com/andybalholm/brotli"
+    "github.com/klauspost/compress/zstd"
+)
+
+func (b *CapturedBody) decode(header http.Header) {
+    encodings := contentEncodings(header)
+    if len(encodings) == 0 || len(b.Data) == 0 {
+        return
+    }
+    b.Encoding = strings.Join(encodings, ", ")
+
+    data := b.Data
+    truncated := b.Truncated
+    for i := len(encodings) - 1; i >= 0; i-- {
+        decoded, partial, err := decodeContent(encodings[i], data, truncated)
+        if err != nil {
+            b.DecodeError = fmt.Sprintf("%s: %v", encodings[i], err)
+            return
+        }
+        data, truncated = decoded, truncated || partial
+    }
+
+    b.Decoded = data
+    b.DecodedTruncated = truncated
+}
+
+func (b *CapturedBody) Readable() []byte {
+    if b.Decoded != nil {
+        return b.Decoded
+    }
+    return b.Data
+}
+
+func contentEncodings(header http.Header) []string {
+    var encodings []string
+    for _, field := range header.Values("Content-Encoding") {
+        for _, encoding := range strings.Split(field, ",") {
+            encoding = strings.ToLower(strings.TrimSpace(encoding))
+            if encoding != "" && encoding != "identity" {
+                encodings = append(encodings, encoding)
+            }
+        }
+    }
+    return encodings
+}
+
+func decodeContent(encoding string, data []byte, truncated bool) ([]byte, bool, error) {
+    reader, err := newContentDecoder(encoding, data)
+    if err != nil {
+        return nil, false, err
+    }
+    defer reader.Close()
+
+    limit := atomic.LoadInt64(&captureLimit)
+    decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+    partial := int64(len(decoded)) > limit
+    if partial {
+        decoded = decoded[:limit]
+    }
+
+    switch {
+    case err == nil:
+        return decoded, partial, nil
+    case truncated && errors.Is(err, io.ErrUnexpectedEOF):
+        return decoded, true, nil
+    default:
+        return nil, false, err
+    }
+}
+
+func newContentDecoder(encoding string, data []byte) (io.ReadCloser, error) {
+    switch encoding {
+    case "gzip", "x-gzip":
+        return gzip.NewReader(bytes.NewReader(data))
+    case "deflate":
+        if reader, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+            return reader, nil
+        }
+        return flate.NewReader(bytes.NewReader(data)), nil
+    case "br":
+        return io.NopCloser(brotli.NewReader(bytes.NewReader(data))), nil
+    case "zstd":
+        decoder, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+        if err != nil {
+            return nil, err
+        }
+        return decoder.IOReadCloser(), nil
+    default:
+        return nil, fmt.Errorf("кодирование не поддерживается")
+    }
+}
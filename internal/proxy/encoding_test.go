@@ -0,0 +1,114 @@
+package proxy
+
+import (
+    "bytes"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "io"
+    "net/http"
+    "testing"
+
+    "github.com/andybalholm/brotli"
+    "github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+    t.Helper()
+
+    var (
+        buffer bytes.Buffer
+        writer io.WriteCloser
+        err    error
+    )
+    switch encoding {
+    case "gzip":
+        writer = gzip.NewWriter(&buffer)
+    case "deflate":
+        writer = zlib.NewWriter(&buffer)
+    case "raw-deflate":
+        writer, err = flate.NewWriter(&buffer, flate.DefaultCompression)
+    case "br":
+        writer = brotli.NewWriter(&buffer)
+    case "zstd":
+        writer, err = zstd.NewWriter(&buffer)
+    default:
+        t.Fatalf("неизвестное кодирование %s", encoding)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := writer.Write(data); err != nil {
+        t.Fatal(err)
+    }
+    if err := writer.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buffer.Bytes()
+}
+
+func TestCapturedBodyDecode(t *testing.T) {
+    plain := []byte(`{"message":"hello, world"}`)
+
+    tests := []struct {
+        name        string
+        header      string
+        data        []byte
+        wantDecoded []byte
+        wantError   bool
+    }{
+        {name: "gzip", header: "gzip", data: compress(t, "gzip", plain), wantDecoded: plain},
+        {name: "x-gzip", header: "x-gzip", data: compress(t, "gzip", plain), wantDecoded: plain},
+        {name: "deflate zlib", header: "deflate", data: compress(t, "deflate", plain), wantDecoded: plain},
+        {name: "deflate без zlib", header: "deflate", data: compress(t, "raw-deflate", plain), wantDecoded: plain},
+        {name: "brotli", header: "br", data: compress(t, "br", plain), wantDecoded: plain},
+        {name: "zstd", header: "zstd", data: compress(t, "zstd", plain), wantDecoded: plain},
+        {name: "цепочка gzip, br", header: "gzip, br", data: compress(t, "br", compress(t, "gzip", plain)), wantDecoded: plain},
+        {name: "identity", header: "identity", data: plain},
+        {name: "битый gzip", header: "gzip", data: []byte("not gzip at all"), wantError: true},
+        {name: "битый zstd", header: "zstd", data: []byte("not zstd at all"), wantError: true},
+        {name: "неизвестное кодирование", header: "compress", data: plain, wantError: true},
+    }
+
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            body := CapturedBody{Data: tc.data, Size: int64(len(tc.data))}
+            body.decode(http.Header{"Content-Encoding": {tc.header}})
+
+            if tc.wantError {
+                if body.DecodeError == "" || body.Decoded != nil {
+                    t.Fatalf("ожидалась пометка об ошибке, получено %+v", body)
+                }
+                if !bytes.Equal(body.Readable(), tc.data) {
+                    t.Fatal("исходные данные должны сохраняться при ошибке")
+                }
+                return
+            }
+            if body.DecodeError != "" {
+                t.Fatalf("неожиданная ошибка: %s", body.DecodeError)
+            }
+            if !bytes.Equal(body.Decoded, tc.wantDecoded) {
+                t.Fatalf("Decoded = %q, ожидалось %q", body.Decoded, tc.wantDecoded)
+            }
+            if !bytes.Equal(body.Data, tc.data) {
+                t.Fatal("исходные данные изменены")
+            }
+        })
+    }
+}
+
+func TestCapturedBodyDecodeTruncated(t *testing.T) {
+    plain := bytes.Repeat([]byte("0123456789"), 1000)
+    encoded := compress(t, "gzip", plain)
+
+    body := CapturedBody{Data: encoded[:len(encoded)/2], Size: int64(len(encoded)), Truncated: true}
+    body.decode(http.Header{"Content-Encoding": {"gzip"}})
+
+    if body.DecodeError != "" {
+        t.Fatalf("усечённое тело не должно считаться битым: %s", body.DecodeError)
+    }
+    if !body.DecodedTruncated || len(body.Decoded) == 0 || !bytes.HasPrefix(plain, body.Decoded) {
+        t.Fatalf("ожидался усечённый префикс, получено %d байт (truncated=%v)", len(body.Decoded), body.DecodedTruncated)
+    }
+}
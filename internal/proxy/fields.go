@@ -0,0 +1,146 @@
+package proxy
+
+import (
+    "net/http"
+    "sort"
+    "strings"
+)
+
+type HeaderField struct {
+    Name  string `json:"name"`
+    Value string `json:"value"`
+}
+
+type HeaderList []HeaderField
+
+func parseHeaderFields(rawHeader []byte) HeaderList {
+    lines := strings.Split(string(rawHeader), "\n")
+    fields := make(HeaderList, 0, len(lines))
+
+    for _, line := range lines[1:] {
+        line = strings.TrimSuffix(line, "\r")
+        if line == "" {
+            continue
+        }
+        if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+            last := &fields[len(fields)-1]
+            last.Value += " " + strings.Trim(line, " \t")
+            continue
+        }
+
+        name, value, found := strings.Cut(line, ":")
+        if !found {
+            continue
+        }
+        fields = append(fields, HeaderField{Name: name, Value: strings.Trim(value, " \t")})
+    }
+    return fields
+}
+
+func (l HeaderList) Has(name string) bool {
+    for _, field := range l {
+        if strings.EqualFold(field.Name, name) {
+            return true
+        }
+    }
+    return false
+}
+
+func (l HeaderList) Header() http.Header {
+    header := make(http.Header, len(l))
+    for _, field := range l {
+        header.Add(field.Name, field.Value)
+    }
+    return header
+}
+
+func (l HeaderList) merge(header http.Header) HeaderList {
+    remaining := make(map[string][]string, len(header))
+    for key, values := range header {
+        key = http.CanonicalHeaderKey(key)
+        remaining[key] = append(remaining[key], values...)
+    }
+
+    merged := make(HeaderList, 0, len(l)+len(remaining))
+    for _, field := range l {
+        key := http.CanonicalHeaderKey(field.Name)
+        values := remaining[key]
+        if len(values) == 0 {
+            continue
+        }
+        merged = append(merged, HeaderField{Name: field.Name, Value: values[0]})
+        remaining[key] = values[1:]
+    }
+
+    keys := make([]string, 0, len(remaining))
+    for key, values := range remaining {
+        if len(values) > 0 {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        for _, value := range remaining[key] {
+            merged = append(merged, HeaderField{Name: key, Value: value})
+        }
+    }
+    return merged
+}
+
+func (l HeaderList) writeTo(builder *strings.Builder) {
+    for _, field := range l {
+        builder.WriteString(field.Name)
+        builder.WriteString(": ")
+        builder.WriteString(field.Value)
+        builder.WriteString("\r\n")
+    }
+}
+
+func (l HeaderList) Get(name string) string {
+    for _, field := range l {
+        if strings.EqualFold(field.Name, name) {
+            return field.Value
+        }
+    }
+    return ""
+}
+
+func (l HeaderList) Values(name string) []string {
+    var values []string
+    for _, field := range l {
+        if strings.EqualFold(field.Name, name) {
+            values = append(values, field.Value)
+        }
+    }
+    return values
+}
+
+func (l HeaderList) Set(name, value string) HeaderList {
+    result := make(HeaderList, 0, len(l)+1)
+    replaced := false
+    for _, field := range l {
+        if !strings.EqualFold(field.Name, name) {
+            result = append(result, field)
+            continue
+        }
+        if !replaced {
+            result = append(result, HeaderField{Name: field.Name, Value: value})
+            replaced = true
+        }
+    }
+    if !replaced {
+        result = append(result, HeaderField{Name: name, Value: value})
+    }
+    return result
+}
+
+func (l HeaderList) Del(name string) HeaderList {
+    result := make(HeaderList, 0, len(l))
+    for _, field := range l {
+        if !strings.EqualFold(field.Name, name) {
+            result = append(result, field)
+        }
+    }
+    return result
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+    "bufio"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func useTestStore(t *testing.T) {
+    t.Helper()
+
+    previous := defaultStore
+    defaultStore = NewCertificateStore()
+    t.Cleanup(func() { defaultStore = previous })
+}
+
+func closedPort(t *testing.T) string {
+    t.Helper()
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, port, _ := net.SplitHostPort(listener.Addr().String())
+    listener.Close()
+    return port
+}
+
+const testServerName = "intercepted.example"
+
+func openTestTunnel(t *testing.T, targetPort string) (*TLSConnectionManager, *tls.Conn, net.Conn, <-chan error) {
+    t.Helper()
+
+    clientSide, proxySide := net.Pipe()
+    manager := &TLSConnectionManager{
+        connectionID: nextConnectionID(),
+        clientConn:   proxySide,
+        serverName:   "127.0.0.1",
+        targetPort:   targetPort,
+    }
+    done := make(chan error, 1)
+    go func() {
+        done <- manager.establishTLSConnection()
+        proxySide.Close()
+    }()
+
+    clientSide.SetDeadline(time.Now().Add(10 * time.Second))
+    reader := bufio.NewReader(clientSide)
+    response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+    if err != nil {
+        t.Fatalf("ответ на CONNECT: %v", err)
+    }
+    if response.StatusCode != http.StatusOK {
+        t.Fatalf("статус CONNECT = %d, ожидался 200", response.StatusCode)
+    }
+
+    roots := x509.NewCertPool()
+    roots.AddCert(certManager.rootCertificate)
+
+    client := tls.Client(&bufferedConn{Conn: clientSide, reader: reader}, &tls.Config{
+        ServerName: testServerName,
+        RootCAs:    roots,
+    })
+    if err := client.Handshake(); err != nil {
+        t.Fatalf("TLS рукопожатие: %v", err)
+    }
+    return manager, client, clientSide, done
+}
+
+func collectExchanges(t *testing.T) <-chan *CapturedExchange {
+    t.Helper()
+
+    exchanges := make(chan *CapturedExchange, 8)
+    captureMutex.Lock()
+    previous := captureHooks
+    captureHooks = []CaptureHook{func(exchange *CapturedExchange) { exchanges <- exchange }}
+    captureMutex.Unlock()
+
+    t.Cleanup(func() {
+        captureMutex.Lock()
+        captureHooks = previous
+        captureMutex.Unlock()
+    })
+    return exchanges
+}
+
+func TestEstablishTLSConnectionIssuesLeafFromCA(t *testing.T) {
+    useTestStore(t)
+
+    if err := defaultStore.Ready(); err == nil {
+        t.Fatal("Ready() без загруженного CA должен возвращать ошибку")
+    }
+
+    certPath, keyPath := writeTestAuthority(t)
+    if err := LoadCA(certPath, keyPath); err != nil {
+        t.Fatalf("LoadCA: %v", err)
+    }
+    if err := defaultStore.Ready(); err != nil {
+        t.Fatalf("Ready() после LoadCA: %v", err)
+    }
+
+    manager, client, clientSide, done := openTestTunnel(t, closedPort(t))
+    defer clientSide.Close()
+
+    roots := x509.NewCertPool()
+    roots.AddCert(certManager.rootCertificate)
+
+    leaf := client.ConnectionState().PeerCertificates[0]
+    if _, err := leaf.Verify(x509.VerifyOptions{
+        DNSName:   testServerName,
+        Roots:     roots,
+        KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    }); err != nil {
+        t.Fatalf("лист не связан с CA: %v", err)
+    }
+
+    clientSide.Close()
+    <-done
+
+    if manager.sniName != testServerName {
+        t.Errorf("sniName = %q, ожидалось %q", manager.sniName, testServerName)
+    }
+}
+
+func TestRejectedTunnelIsCaptured(t *testing.T) {
+    useTestStore(t)
+    certPath, keyPath := writeTestAuthority(t)
+    if err := LoadCA(certPath, keyPath); err != nil {
+        t.Fatalf("LoadCA: %v", err)
+    }
+
+    untrusted := httptest.NewUnstartedServer(http.NotFoundHandler())
+    untrusted.Config.ErrorLog = log.New(io.Discard, "", 0)
+    untrusted.StartTLS()
+    defer untrusted.Close()
+    _, untrustedPort, _ := net.SplitHostPort(untrusted.Listener.Addr().String())
+
+    tests := []struct {
+        name        string
+        port        string
+        contentType string
+        chain       bool
+    }{
+        {name: "сервер недоступен", port: closedPort(t), contentType: "text/plain"},
+        {name: "непроверенный сертификат", port: untrustedPort, contentType: "text/html", chain: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            exchanges := collectExchanges(t)
+            _, client, clientSide, done := openTestTunnel(t, test.port)
+            defer clientSide.Close()
+
+            request, _ := http.NewRequest(http.MethodGet, "https://"+testServerName+"/path?x=1", nil)
+            if err := request.Write(client); err != nil {
+                t.Fatalf("запись запроса: %v", err)
+            }
+            response, err := http.ReadResponse(bufio.NewReader(client), request)
+            if err != nil {
+                t.Fatalf("чтение ответа: %v", err)
+            }
+            response.Body.Close()
+            if response.StatusCode != http.StatusBadGateway {
+                t.Errorf("статус = %d, ожидался 502", response.StatusCode)
+            }
+            if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, test.contentType) {
+                t.Errorf("Content-Type = %q, ожидался %s", contentType, test.contentType)
+            }
+
+            clientSide.Close()
+            if err := <-done; err == nil {
+                t.Error("establishTLSConnection должен вернуть ошибку подключения")
+            }
+
+            select {
+            case exchange := <-exchanges:
+                if exchange.Error == "" {
+                    t.Error("ошибка подключения не записана в обмен")
+                }
+                if exchange.Method != http.MethodGet || exchange.Target != "/path?x=1" {
+                    t.Errorf("записан запрос %s %s", exchange.Method, exchange.Target)
+                }
+                if exchange.Host != testServerName+":"+test.port {
+                    t.Errorf("Host = %q", exchange.Host)
+                }
+                if hasChain := exchange.TLS != nil && len(exchange.TLS.PeerCertificates) > 0; hasChain != test.chain {
+                    t.Errorf("цепочка сертификатов записана = %v, ожидалось %v", hasChain, test.chain)
+                }
+            default:
+                t.Fatal("неудачное подключение не попало в историю")
+            }
+        })
+    }
+}
+
+func TestRelayUpgradedConnectionClosesBothSides(t *testing.T) {
+    tests := []struct {
+        name   string
+        closer func(client, upstream net.Conn) net.Conn
+    }{
+        {name: "клиент отключился", closer: func(client, upstream net.Conn) net.Conn {
+            client.Close()
+            return upstream
+        }},
+        {name: "сервер отключился", closer: func(client, upstream net.Conn) net.Conn {
+            upstream.Close()
+            return client
+        }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            clientPeer, clientProxy := net.Pipe()
+            upstreamProxy, upstreamPeer := net.Pipe()
+            defer clientPeer.Close()
+            defer upstreamPeer.Close()
+
+            done := make(chan error, 1)
+            go func() {
+                done <- relayUpgradedConnection(clientProxy, newUpstreamConn(upstreamProxy))
+            }()
+
+            clientPeer.SetDeadline(time.Now().Add(5 * time.Second))
+            upstreamPeer.SetDeadline(time.Now().Add(5 * time.Second))
+
+            go clientPeer.Write([]byte("ping"))
+            buffer := make([]byte, 4)
+            if _, err := io.ReadFull(upstreamPeer, buffer); err != nil || string(buffer) != "ping" {
+                t.Fatalf("данные клиента не дошли до сервера: %q, %v", buffer, err)
+            }
+
+            remaining := test.closer(clientPeer, upstreamPeer)
+            if _, err := remaining.Read(buffer); !errors.Is(err, io.EOF) {
+                t.Errorf("вторая сторона не закрыта: %v", err)
+            }
+
+            select {
+            case err := <-done:
+                if err != nil {
+                    t.Errorf("relayUpgradedConnection: %v", err)
+                }
+            case <-time.After(5 * time.Second):
+                t.Fatal("relayUpgradedConnection не завершился")
+            }
+        })
+    }
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+    "sync/atomic"
+)
+
+const viaPseudonym = "security-technopark"
+
+var (
+    hopByHopHeaders = []string{
+        "Connection",
+        "Proxy-Connection",
+        "Keep-Alive",
+        "Proxy-Authenticate",
+        "Proxy-Authorization",
+        "TE",
+        "Trailer",
+        "Transfer-Encoding",
+        "Upgrade",
+    }
+    forwardedHeadersEnabled atomic.Bool
+)
+
+func SetForwardedHeaders(enabled bool) {
+    forwardedHeadersEnabled.Store(enabled)
+}
+
+func removeHopByHopHeaders(header http.Header) {
+    for _, field := range header.Values("Connection") {
+        for _, name := range strings.Split(field, ",") {
+            if name = strings.TrimSpace(name); name != "" {
+                header.Del(name)
+            }
+        }
+    }
+
+    for _, name := range hopByHopHeaders {
+        header.Del(name)
+    }
+}
+
+func expectsContinue(request *http.Request) bool {
+    return strings.EqualFold(strings.TrimSpace(request.Header.Get("Expect")), "100-continue")
+}
+
+func upgradeProtocol(header http.Header) string {
+    for _, field := range header.Values("Connection") {
+        for _, name := range strings.Split(field, ",") {
+            if strings.EqualFold(strings.TrimSpace(name), "upgrade") {
+                return header.Get("Upgrade")
+            }
+        }
+    }
+    return ""
+}
+
+func acceptsTrailers(header http.Header) bool {
+    for _, field := range header.Values("TE") {
+        for _, value := range strings.Split(field, ",") {
+            if strings.EqualFold(strings.TrimSpace(value), "trailers") {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func prepareOutgoingRequest(request *http.Request, clientAddr net.Addr) {
+    upgrade := upgradeProtocol(request.Header)
+    trailers := acceptsTrailers(request.Header)
+
+    removeHopByHopHeaders(request.Header)
+    if expectsContinue(request) {
+        request.Header.Del("Expect")
+    }
+
+    if upgrade != "" {
+        request.Header.Set("Connection", "Upgrade")
+        request.Header.Set("Upgrade", upgrade)
+    }
+    if trailers {
+        request.Header.Set("TE", "trailers")
+    }
+
+    appendVia(request.Header, request.ProtoMajor, request.ProtoMinor)
+
+    if forwardedHeadersEnabled.Load() && clientAddr != nil {
+        appendForwarded(request, clientAddr)
+    }
+}
+
+func prepareOutgoingResponse(response *http.Response) {
+    upgrade := ""
+    if response.StatusCode == http.StatusSwitchingProtocols {
+        upgrade = upgradeProtocol(response.Header)
+    }
+
+    removeHopByHopHeaders(response.Header)
+
+    if upgrade != "" {
+        response.Header.Set("Connection", "Upgrade")
+        response.Header.Set("Upgrade", upgrade)
+    }
+
+    appendVia(response.Header, response.ProtoMajor, response.ProtoMinor)
+}
+
+func appendVia(header http.Header, major, minor int) {
+    if major == 0 {
+        major, minor = 1, 1
+    }
+    header.Add("Via", fmt.Sprintf("%d.%d %s", major, minor, viaPseudonym))
+}
+
+func appendForwarded(request *http.Request, clientAddr net.Addr) {
+    clientIP := clientAddr.String()
+    if host, _, err := net.SplitHostPort(clientIP); err == nil {
+        clientIP = host
+    }
+
+    if prior := request.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+        request.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+    } else {
+        request.Header.Set("X-Forwarded-For", clientIP)
+    }
+
+    node := clientIP
+    if strings.Contains(node, ":") {
+        node = `"[` + node + `]"`
+    }
+    request.Header.Add("Forwarded", fmt.Sprintf("for=%s;host=%q;proto=%s",
+        node, request.Host, strings.ToLower(request.URL.Scheme)))
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func newTestAuthority(tb testing.TB) *CertificateManager {
+    tb.Helper()
+
+    certPEM, keyPEM, err := generateRootCertificate()
+    if err != nil {
+        tb.Fatalf("генерация тестового CA: %v", err)
+    }
+
+    manager := &CertificateManager{}
+    if err := manager.processCertificateData(certPEM, keyPEM); err != nil {
+        tb.Fatalf("разбор тестового CA: %v", err)
+    }
+    return manager
+}
+
+func writeTestAuthority(tb testing.TB) (string, string) {
+    tb.Helper()
+
+    certPEM, keyPEM, err := generateRootCertificate()
+    if err != nil {
+        tb.Fatalf("генерация тестового CA: %v", err)
+    }
+
+    dir := tb.TempDir()
+    certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+    if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+        tb.Fatal(err)
+    }
+    if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+        tb.Fatal(err)
+    }
+    return certPath, keyPath
+}
+
+func newTestGenerator(tb testing.TB, authority *CertificateManager, keyType KeyType) *CertificateGenerator {
+    tb.Helper()
+
+    generator := NewCertificateGenerator(keyType)
+    generator.rootCert = authority.rootCertificate
+    generator.rootKey = authority.privateKey
+    tb.Cleanup(generator.keyPool.shutdown)
+    return generator
+}
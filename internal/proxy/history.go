@@ -0,0 +1,361 @@
+package proxy
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    ErrHistoryNotFound = errors.New("запись истории не найдена")
+    errHistoryDisabled = errors.New("история запросов не подключена")
+)
+
+type HistoryEntry struct {
+    ID       uint64 `json:"id"`
+    RepeatOf uint64 `json:"repeat_of,omitempty"`
+    CapturedExchange
+}
+
+type HistorySummary struct {
+    ID           uint64    `json:"id"`
+    RepeatOf     uint64    `json:"repeat_of,omitempty"`
+    Method       string    `json:"method"`
+    Scheme       string    `json:"scheme"`
+    Host         string    `json:"host"`
+    Target       string    `json:"target"`
+    StatusCode   int       `json:"status_code"`
+    ClientAddr   string    `json:"client_addr"`
+    RequestSize  int64     `json:"request_size"`
+    ResponseSize int64     `json:"response_size"`
+    StartedAt    time.Time `json:"started_at"`
+    DurationMS   int64     `json:"duration_ms"`
+    Error        string    `json:"error,omitempty"`
+}
+
+type HistoryQuery struct {
+    Method     string
+    Scheme     string
+    Host       string
+    StatusCode int
+    Search     string
+    BodySearch string
+    Since      time.Time
+    Until      time.Time
+    Offset     int
+    Limit      int
+}
+
+type HistoryPage struct {
+    Total int              `json:"total"`
+    Items []HistorySummary `json:"items"`
+}
+
+type HistoryStorage interface {
+    Save(entry *HistoryEntry) error
+    Get(id uint64) (*HistoryEntry, error)
+    List(query HistoryQuery) (HistoryPage, error)
+    Delete(id uint64) error
+}
+
+var (
+    historyMutex   sync.RWMutex
+    historyStorage HistoryStorage
+)
+
+func UseHistoryStorage(storage HistoryStorage) {
+    historyMutex.Lock()
+    defer historyMutex.Unlock()
+    historyStorage = storage
+}
+
+func UseHistoryDirectory(path string) error {
+    storage, err := NewFileHistory(path)
+    if err != nil {
+        return err
+    }
+    UseHistoryStorage(storage)
+    return nil
+}
+
+func currentHistory() (HistoryStorage, error) {
+    historyMutex.RLock()
+    defer historyMutex.RUnlock()
+    if historyStorage == nil {
+        return nil, errHistoryDisabled
+    }
+    return historyStorage, nil
+}
+
+func ListHistory(query HistoryQuery) (HistoryPage, error) {
+    storage, err := currentHistory()
+    if err != nil {
+        return HistoryPage{}, err
+    }
+    return storage.List(query)
+}
+
+func GetHistoryEntry(id uint64) (*HistoryEntry, error) {
+    storage, err := currentHistory()
+    if err != nil {
+        return nil, err
+    }
+    return storage.Get(id)
+}
+
+func DeleteHistoryEntry(id uint64) error {
+    storage, err := currentHistory()
+    if err != nil {
+        return err
+    }
+    return storage.Delete(id)
+}
+
+func recordHistory(exchange *CapturedExchange) {
+    storage, err := currentHistory()
+    if err != nil {
+        return
+    }
+
+    if err := storage.Save(&HistoryEntry{CapturedExchange: *exchange}); err != nil {
+        fmt.Printf("Предупреждение: не удалось сохранить запрос в историю: %v\n", err)
+    }
+}
+
+func saveHistoryEntry(entry *HistoryEntry) error {
+    storage, err := currentHistory()
+    if err != nil {
+        return err
+    }
+    return storage.Save(entry)
+}
+
+func (e *HistoryEntry) Summary() HistorySummary {
+    return HistorySummary{
+        ID:           e.ID,
+        RepeatOf:     e.RepeatOf,
+        Method:       e.Method,
+        Scheme:       e.Scheme,
+        Host:         e.Host,
+        Target:       e.Target,
+        StatusCode:   e.StatusCode,
+        ClientAddr:   e.ClientAddr,
+        RequestSize:  e.RequestBody.Size,
+        ResponseSize: e.ResponseBody.Size,
+        StartedAt:    e.StartedAt,
+        DurationMS:   e.FinishedAt.Sub(e.StartedAt).Milliseconds(),
+        Error:        e.Error,
+    }
+}
+
+func (q HistoryQuery) matches(summary *HistorySummary) bool {
+    switch {
+    case q.Method != "" && !strings.EqualFold(summary.Method, q.Method):
+        return false
+    case q.Scheme != "" && !strings.EqualFold(summary.Scheme, q.Scheme):
+        return false
+    case q.Host != "" && !strings.Contains(strings.ToLower(summary.Host), strings.ToLower(q.Host)):
+        return false
+    case q.StatusCode != 0 && summary.StatusCode != q.StatusCode:
+        return false
+    case !q.Since.IsZero() && summary.StartedAt.Before(q.Since):
+        return false
+    case !q.Until.IsZero() && summary.StartedAt.After(q.Until):
+        return false
+    case q.Search != "" && !strings.Contains(summary.Target, q.Search) && !strings.Contains(summary.Error, q.Search):
+        return false
+    }
+    return true
+}
+
+func (e *HistoryEntry) bodiesContain(search string) bool {
+    return bytes.Contains(e.RequestBody.Readable(), []byte(search)) ||
+        bytes.Contains(e.ResponseBody.Readable(), []byte(search))
+}
+
+type FileHistory struct {
+    path      string
+    mutex     sync.Mutex
+    ids       []uint64
+    summaries map[uint64]*HistorySummary
+    nextID    uint64
+}
+
+func NewFileHistory(path string) (*FileHistory, error) {
+    if err := os.MkdirAll(path, 0o700); err != nil {
+        return nil, fmt.Errorf("ошибка создания каталога истории %s: %w", path, err)
+    }
+
+    files, err := os.ReadDir(path)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка чтения каталога истории %s: %w", path, err)
+    }
+
+    history := &FileHistory{path: path, nextID: 1, summaries: make(map[uint64]*HistorySummary)}
+    for _, file := range files {
+        name, found := strings.CutSuffix(file.Name(), ".json")
+        if !found || file.IsDir() {
+            continue
+        }
+        id, err := strconv.ParseUint(name, 10, 64)
+        if err != nil {
+            continue
+        }
+
+        summary, err := history.loadSummary(id)
+        if err != nil {
+            fmt.Printf("Предупреждение: запись истории %d пропущена: %v\n", id, err)
+            continue
+        }
+        history.ids = append(history.ids, id)
+        history.summaries[id] = summary
+        history.nextID = max(history.nextID, id+1)
+    }
+    sort.Slice(history.ids, func(i, j int) bool { return history.ids[i] < history.ids[j] })
+
+    return history, nil
+}
+
+func (h *FileHistory) filePath(id uint64) string {
+    return filepath.Join(h.path, strconv.FormatUint(id, 10)+".json")
+}
+
+func (h *FileHistory) summaryPath(id uint64) string {
+    return filepath.Join(h.path, strconv.FormatUint(id, 10)+".meta.json")
+}
+
+func (h *FileHistory) loadSummary(id uint64) (*HistorySummary, error) {
+    if data, err := os.ReadFile(h.summaryPath(id)); err == nil {
+        summary := &HistorySummary{}
+        if err := json.Unmarshal(data, summary); err == nil && summary.ID == id {
+            return summary, nil
+        }
+    }
+
+    entry, err := h.Get(id)
+    if err != nil {
+        return nil, err
+    }
+    summary := entry.Summary()
+    if err := h.writeSummary(&summary); err != nil {
+        fmt.Printf("Предупреждение: не удалось сохранить индекс записи %d: %v\n", id, err)
+    }
+    return &summary, nil
+}
+
+func (h *FileHistory) writeSummary(summary *HistorySummary) error {
+    data, err := json.Marshal(summary)
+    if err != nil {
+        return err
+    }
+    return writeFileAtomically(h.summaryPath(summary.ID), data, 0o600)
+}
+
+func (h *FileHistory) Save(entry *HistoryEntry) error {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    if entry.ID == 0 {
+        entry.ID = h.nextID
+    }
+
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return fmt.Errorf("ошибка кодирования записи %d: %w", entry.ID, err)
+    }
+    summary := entry.Summary()
+    if err := h.writeSummary(&summary); err != nil {
+        return err
+    }
+    if err := writeFileAtomically(h.filePath(entry.ID), data, 0o600); err != nil {
+        os.Remove(h.summaryPath(entry.ID))
+        return err
+    }
+
+    h.nextID = max(h.nextID, entry.ID+1)
+    h.summaries[entry.ID] = &summary
+    index := sort.Search(len(h.ids), func(i int) bool { return h.ids[i] >= entry.ID })
+    if index == len(h.ids) || h.ids[index] != entry.ID {
+        h.ids = append(h.ids, 0)
+        copy(h.ids[index+1:], h.ids[index:])
+        h.ids[index] = entry.ID
+    }
+    return nil
+}
+
+func (h *FileHistory) Get(id uint64) (*HistoryEntry, error) {
+    data, err := os.ReadFile(h.filePath(id))
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("%w: %d", ErrHistoryNotFound, id)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    entry := &HistoryEntry{}
+    if err := json.Unmarshal(data, entry); err != nil {
+        return nil, fmt.Errorf("ошибка чтения записи %d: %w", id, err)
+    }
+    return entry, nil
+}
+
+func (h *FileHistory) List(query HistoryQuery) (HistoryPage, error) {
+    h.mutex.Lock()
+    candidates := make([]HistorySummary, 0, len(h.ids))
+    for i := len(h.ids) - 1; i >= 0; i-- {
+        if summary := h.summaries[h.ids[i]]; query.matches(summary) {
+            candidates = append(candidates, *summary)
+        }
+    }
+    h.mutex.Unlock()
+
+    page := HistoryPage{Items: []HistorySummary{}}
+    for _, summary := range candidates {
+        if query.BodySearch != "" {
+            entry, err := h.Get(summary.ID)
+            if errors.Is(err, ErrHistoryNotFound) {
+                continue
+            }
+            if err != nil {
+                return HistoryPage{}, err
+            }
+            if !entry.bodiesContain(query.BodySearch) {
+                continue
+            }
+        }
+
+        if page.Total >= query.Offset && (query.Limit <= 0 || len(page.Items) < query.Limit) {
+            page.Items = append(page.Items, summary)
+        }
+        page.Total++
+    }
+    return page, nil
+}
+
+func (h *FileHistory) Delete(id uint64) error {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    if err := os.Remove(h.filePath(id)); err != nil {
+        if os.IsNotExist(err) {
+            return fmt.Errorf("%w: %d", ErrHistoryNotFound, id)
+        }
+        return err
+    }
+    os.Remove(h.summaryPath(id))
+
+    delete(h.summaries, id)
+    index := sort.Search(len(h.ids), func(i int) bool { return h.ids[i] >= id })
+    if index < len(h.ids) && h.ids[index] == id {
+        h.ids = append(h.ids[:index], h.ids[index+1:]...)
+    }
+    return nil
+}
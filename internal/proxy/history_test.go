@@ -0,0 +1,146 @@
+package proxy
+
+import (
+    "errors"
+    "os"
+    "testing"
+    "time"
+)
+
+func saveTestEntries(t *testing.T, history *FileHistory) {
+    t.Helper()
+
+    start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    entries := []CapturedExchange{
+        {Method: "GET", Scheme: "http", Host: "a.example", Target: "/one", StatusCode: 200},
+        {Method: "POST", Scheme: "https", Host: "b.example", Target: "/login", StatusCode: 302,
+            RequestBody: CapturedBody{Data: []byte("user=admin")}},
+        {Method: "GET", Scheme: "https", Host: "a.example", Target: "/two", StatusCode: 404},
+        {Method: "GET", Scheme: "http", Host: "c.example", Target: "/three", StatusCode: 200},
+    }
+    for i := range entries {
+        entries[i].StartedAt = start.Add(time.Duration(i) * time.Minute)
+        entries[i].FinishedAt = entries[i].StartedAt.Add(time.Second)
+        if err := history.Save(&HistoryEntry{CapturedExchange: entries[i]}); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func pageIDs(page HistoryPage) []uint64 {
+    ids := make([]uint64, 0, len(page.Items))
+    for _, item := range page.Items {
+        ids = append(ids, item.ID)
+    }
+    return ids
+}
+
+func TestFileHistoryList(t *testing.T) {
+    history, err := NewFileHistory(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    saveTestEntries(t, history)
+
+    tests := []struct {
+        name      string
+        query     HistoryQuery
+        wantTotal int
+        wantIDs   []uint64
+    }{
+        {name: "все, новые первыми", wantTotal: 4, wantIDs: []uint64{4, 3, 2, 1}},
+        {name: "страница", query: HistoryQuery{Offset: 1, Limit: 2}, wantTotal: 4, wantIDs: []uint64{3, 2}},
+        {name: "за концом", query: HistoryQuery{Offset: 10, Limit: 2}, wantTotal: 4, wantIDs: []uint64{}},
+        {name: "метод", query: HistoryQuery{Method: "get"}, wantTotal: 3, wantIDs: []uint64{4, 3, 1}},
+        {name: "хост и схема", query: HistoryQuery{Host: "A.EXAMPLE", Scheme: "https"}, wantTotal: 1, wantIDs: []uint64{3}},
+        {name: "статус", query: HistoryQuery{StatusCode: 200, Limit: 1}, wantTotal: 2, wantIDs: []uint64{4}},
+        {name: "поиск по цели", query: HistoryQuery{Search: "/t"}, wantTotal: 2, wantIDs: []uint64{4, 3}},
+        {name: "интервал времени", query: HistoryQuery{
+            Since: time.Date(2026, 1, 1, 0, 1, 0, 0, time.UTC),
+            Until: time.Date(2026, 1, 1, 0, 2, 0, 0, time.UTC),
+        }, wantTotal: 2, wantIDs: []uint64{3, 2}},
+        {name: "поиск по телу", query: HistoryQuery{BodySearch: "admin"}, wantTotal: 1, wantIDs: []uint64{2}},
+    }
+
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            page, err := history.List(tc.query)
+            if err != nil {
+                t.Fatal(err)
+            }
+            ids := pageIDs(page)
+            if page.Total != tc.wantTotal || len(ids) != len(tc.wantIDs) {
+                t.Fatalf("total=%d ids=%v, ожидалось total=%d ids=%v", page.Total, ids, tc.wantTotal, tc.wantIDs)
+            }
+            for i := range ids {
+                if ids[i] != tc.wantIDs[i] {
+                    t.Fatalf("ids=%v, ожидалось %v", ids, tc.wantIDs)
+                }
+            }
+        })
+    }
+}
+
+func TestFileHistoryListUsesIndex(t *testing.T) {
+    dir := t.TempDir()
+    history, err := NewFileHistory(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saveTestEntries(t, history)
+
+    for id := uint64(1); id <= 4; id++ {
+        if err := os.WriteFile(history.filePath(id), []byte("{broken"), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    reopened, err := NewFileHistory(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    page, err := reopened.List(HistoryQuery{Method: "POST"})
+    if err != nil {
+        t.Fatalf("список не должен читать полные записи: %v", err)
+    }
+    if page.Total != 1 || page.Items[0].Target != "/login" {
+        t.Fatalf("получено %+v", page)
+    }
+}
+
+func TestFileHistoryRebuildsMissingIndex(t *testing.T) {
+    dir := t.TempDir()
+    history, err := NewFileHistory(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saveTestEntries(t, history)
+
+    if err := os.Remove(history.summaryPath(2)); err != nil {
+        t.Fatal(err)
+    }
+
+    reopened, err := NewFileHistory(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if page, _ := reopened.List(HistoryQuery{}); page.Total != 4 {
+        t.Fatalf("после восстановления индекса total=%d, ожидалось 4", page.Total)
+    }
+    if _, err := os.Stat(reopened.summaryPath(2)); err != nil {
+        t.Fatalf("индекс записи 2 не восстановлен: %v", err)
+    }
+
+    if err := reopened.Delete(2); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(reopened.summaryPath(2)); !os.IsNotExist(err) {
+        t.Fatalf("индекс удалённой записи остался: %v", err)
+    }
+    if err := reopened.Delete(2); !errors.Is(err, ErrHistoryNotFound) {
+        t.Fatalf("повторное удаление: %v", err)
+    }
+    if reopened.nextID != 5 {
+        t.Fatalf("nextID = %d, ожидалось 5", reopened.nextID)
+    }
+}
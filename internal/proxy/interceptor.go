@@ -0,0 +1,357 @@
+package proxy
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+const clientIdleTimeout = 2 * time.Minute
+
+type RequestHook func(*http.Request)
+
+type ResponseHook func(*http.Response)
+
+type clientSession struct {
+    connectionID uint64
+    clientConn   net.Conn
+    reader       *bufio.Reader
+    tunnel       *TLSConnectionManager
+}
+
+var (
+    hooksMutex    sync.RWMutex
+    requestHooks  []RequestHook
+    responseHooks []ResponseHook
+)
+
+func AddRequestHook(hook RequestHook) {
+    hooksMutex.Lock()
+    defer hooksMutex.Unlock()
+    requestHooks = append(requestHooks, hook)
+}
+
+func AddResponseHook(hook ResponseHook) {
+    hooksMutex.Lock()
+    defer hooksMutex.Unlock()
+    responseHooks = append(responseHooks, hook)
+}
+
+func runRequestHooks(request *http.Request) {
+    hooksMutex.RLock()
+    hooks := requestHooks
+    hooksMutex.RUnlock()
+
+    for _, hook := range hooks {
+        hook(request)
+    }
+}
+
+func runResponseHooks(response *http.Response) {
+    hooksMutex.RLock()
+    hooks := responseHooks
+    hooksMutex.RUnlock()
+
+    for _, hook := range hooks {
+        hook(response)
+    }
+}
+
+func newClientSession(connectionID uint64, clientConn net.Conn, reader *bufio.Reader) *clientSession {
+    return &clientSession{
+        connectionID: connectionID,
+        clientConn:   clientConn,
+        reader:       reader,
+    }
+}
+
+func newUpstreamConn(conn net.Conn) *upstreamConn {
+    return &upstreamConn{conn: conn, reader: bufio.NewReaderSize(conn, clientReaderSize)}
+}
+
+func (s *clientSession) serve() error {
+    for {
+        s.clientConn.SetReadDeadline(time.Now().Add(clientIdleTimeout))
+        request, fields, err := s.readRequest()
+        s.clientConn.SetReadDeadline(time.Time{})
+        if err != nil {
+            if errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded) {
+                return nil
+            }
+            status := http.StatusBadRequest
+            if errors.Is(err, errHeaderTooLarge) {
+                status = http.StatusRequestHeaderFieldsTooLarge
+            }
+            writeErrorResponse(s.clientConn, status, err)
+            return err
+        }
+
+        if request.Method == http.MethodConnect && s.tunnel == nil {
+            return s.handleConnect(request)
+        }
+
+        keepAlive, err := s.serveRequest(request, fields)
+        if err != nil || !keepAlive {
+            return err
+        }
+    }
+}
+
+func (s *clientSession) readRequest() (*http.Request, HeaderList, error) {
+    rawHeader, err := peekHeaderBlock(s.reader)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if err := checkRequestFraming(rawHeader); err != nil {
+        return nil, nil, err
+    }
+    fields := parseHeaderFields(rawHeader)
+
+    request, err := http.ReadRequest(s.reader)
+    return request, fields, err
+}
+
+func (s *clientSession) serveRequest(request *http.Request, requestFields HeaderList) (bool, error) {
+    if err := s.resolveTarget(request); err != nil {
+        writeErrorResponse(s.clientConn, http.StatusBadRequest, err)
+        return false, err
+    }
+
+    if err := enforceBodyLimit(request); err != nil {
+        writeErrorResponse(s.clientConn, http.StatusRequestEntityTooLarge, err)
+        return false, err
+    }
+
+    exchange := newCapturedExchange(s.connectionID, s.clientConn, request.URL.Scheme, request.URL.Host)
+    exchange.recordRequest(request, requestFields)
+
+    if err := s.continueRequestBody(request); err != nil {
+        captureExchange(exchange, err)
+        return false, err
+    }
+
+    runRequestHooks(request)
+    request.Body = captureBody(request.Body, &exchange.RequestBody)
+
+    upstream, response, responseFields, err := s.forwardHTTPRequest(request, requestFields)
+    if err != nil {
+        s.reportForwardError(request, err)
+        captureExchange(exchange, err)
+        return false, err
+    }
+    exchange.TLS = upstream.tls
+    exchange.recordResponse(response, responseFields)
+
+    prepareOutgoingResponse(response)
+    runResponseHooks(response)
+    response.Body = captureBody(response.Body, &exchange.ResponseBody)
+
+    reusable := !response.Close
+    if request.Close {
+        response.Close = true
+    }
+
+    err = writeResponse(s.clientConn, response, responseFields)
+    keepAlive := !response.Close
+    if response.StatusCode == http.StatusSwitchingProtocols {
+        response.Body.Close()
+        captureExchange(exchange, err)
+        connectionPool.detach(upstream)
+        defer upstream.conn.Close()
+        if err != nil {
+            return false, err
+        }
+        return false, relayUpgradedConnection(&bufferedConn{Conn: s.clientConn, reader: s.reader}, upstream)
+    }
+
+    if err != nil || !reusable {
+        connectionPool.discard(upstream)
+        response.Body.Close()
+    } else {
+        response.Body.Close()
+        connectionPool.put(upstream)
+    }
+    captureExchange(exchange, err)
+
+    if err != nil {
+        return false, err
+    }
+    return keepAlive, nil
+}
+
+func (s *clientSession) continueRequestBody(request *http.Request) error {
+    if !expectsContinue(request) {
+        return nil
+    }
+    if request.ContentLength == 0 || !request.ProtoAtLeast(1, 1) {
+        return nil
+    }
+
+    _, err := io.WriteString(s.clientConn, "HTTP/1.1 100 Continue\r\n\r\n")
+    return err
+}
+
+func (s *clientSession) reportForwardError(request *http.Request, err error) {
+    var verifyErr *UpstreamVerificationError
+    switch {
+    case bodyLimitExceeded(request):
+        writeErrorResponse(s.clientConn, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+    case errors.As(err, &verifyErr):
+        writeVerificationFailure(s.clientConn, verifyErr)
+    default:
+        writeErrorResponse(s.clientConn, http.StatusBadGateway, err)
+    }
+}
+
+func (s *clientSession) resolveTarget(request *http.Request) error {
+    origin, err := originForm(request.Method, request.RequestURI)
+    if err != nil {
+        return err
+    }
+    request.RequestURI = origin
+
+    if s.tunnel != nil {
+        request.URL.Scheme = "https"
+        request.URL.Host = s.tunnel.authority()
+        return nil
+    }
+
+    if request.URL.Host == "" {
+        request.URL.Host = request.Host
+    }
+    if request.URL.Scheme == "" {
+        request.URL.Scheme = "http"
+    }
+    if request.URL.Host == "" {
+        return fmt.Errorf("не указан целевой хост: %s", request.RequestURI)
+    }
+    return nil
+}
+
+func (s *clientSession) upstreamTarget(request *http.Request) upstreamTarget {
+    if s.tunnel != nil {
+        return s.tunnel.upstreamTarget()
+    }
+
+    return upstreamTarget{
+        scheme:     strings.ToLower(request.URL.Scheme),
+        address:    upstreamAddress(request),
+        serverName: request.URL.Hostname(),
+    }
+}
+
+func (s *clientSession) forwardHTTPRequest(request *http.Request, fields HeaderList) (*upstreamConn, *http.Response, HeaderList, error) {
+    target := s.upstreamTarget(request)
+
+    prepareOutgoingRequest(request, s.clientAddr())
+
+    for attempt := 0; ; attempt++ {
+        upstream, err := s.acquireUpstream(target)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+
+        response, responseFields, err := roundTrip(upstream, request, fields)
+        if err == nil {
+            return upstream, response, responseFields, nil
+        }
+
+        connectionPool.discard(upstream)
+        if !upstream.reused || attempt > 0 || !isReplayable(request) {
+            return nil, nil, nil, err
+        }
+    }
+}
+
+func (s *clientSession) clientAddr() net.Addr {
+    if s.clientConn == nil {
+        return nil
+    }
+    return s.clientConn.RemoteAddr()
+}
+
+func (s *clientSession) acquireUpstream(target upstreamTarget) (*upstreamConn, error) {
+    return connectionPool.get(target, func() (*upstreamConn, error) {
+        return s.dialUpstream(target)
+    })
+}
+
+func (s *clientSession) dialUpstream(target upstreamTarget) (*upstreamConn, error) {
+    if target.scheme != "https" {
+        conn, err := net.DialTimeout("tcp", target.address, 10*time.Second)
+        if err != nil {
+            return nil, err
+        }
+        return newUpstreamConn(conn), nil
+    }
+
+    conn, err := dialUpstreamTLS(target.address, target.serverName)
+    if err != nil {
+        return nil, err
+    }
+
+    upstream := newUpstreamConn(conn)
+    upstream.tls = recordUpstreamTLS(s.connectionID, target.address, conn.ConnectionState())
+    return upstream, nil
+}
+
+func roundTrip(upstream *upstreamConn, request *http.Request, fields HeaderList) (*http.Response, HeaderList, error) {
+    if err := writeRequest(upstream.conn, request, fields); err != nil {
+        return nil, nil, err
+    }
+
+    for {
+        rawHeader, err := peekHeaderBlock(upstream.reader)
+        if err != nil {
+            return nil, nil, err
+        }
+        responseFields := parseHeaderFields(rawHeader)
+
+        response, err := http.ReadResponse(upstream.reader, request)
+        if err != nil {
+            return nil, nil, err
+        }
+        if isInterimResponse(response) {
+            response.Body.Close()
+            continue
+        }
+        return response, responseFields, nil
+    }
+}
+
+func isInterimResponse(response *http.Response) bool {
+    return response.StatusCode/100 == 1 && response.StatusCode != http.StatusSwitchingProtocols
+}
+
+func isReplayable(request *http.Request) bool {
+    return request.Body == nil || request.Body == http.NoBody
+}
+
+func upstreamAddress(request *http.Request) string {
+    if port := request.URL.Port(); port != "" {
+        return net.JoinHostPort(request.URL.Hostname(), port)
+    }
+    if strings.EqualFold(request.URL.Scheme, "https") {
+        return net.JoinHostPort(request.URL.Hostname(), "443")
+    }
+    return net.JoinHostPort(request.URL.Hostname(), "80")
+}
+
+func writeErrorResponse(clientConn net.Conn, status int, cause error) error {
+    body := fmt.Sprintf("%d %s: %v\n", status, http.StatusText(status), cause)
+    response := fmt.Sprintf("HTTP/1.1 %d %s\r\n"+
+        "Content-Type: text/plain; charset=utf-8\r\n"+
+        "Content-Length: %d\r\n"+
+        "Connection: close\r\n\r\n", status, http.StatusText(status), len(body))
+
+    _, err := clientConn.Write([]byte(response + body))
+    return err
+}
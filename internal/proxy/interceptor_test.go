@@ -0,0 +1,176 @@
+package proxy
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+func startTestProxy(t *testing.T) string {
+    t.Helper()
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go NewConnectionHandler(conn).ProcessRequest()
+        }
+    }()
+    return listener.Addr().String()
+}
+
+func startInterimUpstream(t *testing.T, interim string) string {
+    t.Helper()
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                reader := bufio.NewReader(conn)
+                for {
+                    request, err := http.ReadRequest(reader)
+                    if err != nil {
+                        return
+                    }
+                    body, _ := io.ReadAll(request.Body)
+                    payload := "body-for-" + request.URL.Path + string(body)
+                    fmt.Fprintf(conn, "%sHTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", interim, len(payload), payload)
+                }
+            }()
+        }
+    }()
+    return listener.Addr().String()
+}
+
+func sendThroughProxy(t *testing.T, conn net.Conn, reader *bufio.Reader, raw string) (*http.Response, string) {
+    t.Helper()
+
+    conn.SetDeadline(time.Now().Add(5 * time.Second))
+    if _, err := io.WriteString(conn, raw); err != nil {
+        t.Fatalf("запись запроса: %v", err)
+    }
+    response, err := http.ReadResponse(reader, nil)
+    if err != nil {
+        t.Fatalf("чтение ответа: %v", err)
+    }
+    body, err := io.ReadAll(response.Body)
+    if err != nil {
+        t.Fatalf("чтение тела: %v", err)
+    }
+    return response, string(body)
+}
+
+func TestInterimResponsesAreNotFinal(t *testing.T) {
+    collectExchanges(t)
+    proxyAddr := startTestProxy(t)
+
+    tests := []struct {
+        name    string
+        interim string
+        method  string
+        body    string
+    }{
+        {name: "103 Early Hints", interim: "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n", method: http.MethodGet},
+        {name: "100 Continue", interim: "HTTP/1.1 100 Continue\r\n\r\n", method: http.MethodPost, body: "-payload"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            upstream := startInterimUpstream(t, test.interim)
+
+            request := func(path string) string {
+                return fmt.Sprintf("%s http://%s%s HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n\r\n%s",
+                    test.method, upstream, path, upstream, len(test.body), test.body)
+            }
+
+            first, err := net.Dial("tcp", proxyAddr)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer first.Close()
+            firstReader := bufio.NewReader(first)
+
+            for _, path := range []string{"/hints-1", "/hints-2"} {
+                response, body := sendThroughProxy(t, first, firstReader, request(path))
+                if response.StatusCode != http.StatusOK || body != "body-for-"+path+test.body {
+                    t.Fatalf("%s: получен %d %q", path, response.StatusCode, body)
+                }
+            }
+
+            second, err := net.Dial("tcp", proxyAddr)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer second.Close()
+
+            response, body := sendThroughProxy(t, second, bufio.NewReader(second), request("/other"))
+            if response.StatusCode != http.StatusOK || !strings.HasPrefix(body, "body-for-/other") {
+                t.Fatalf("второй клиент получил %d %q", response.StatusCode, body)
+            }
+        })
+    }
+}
+
+func TestExpectContinueAnsweredByProxy(t *testing.T) {
+    collectExchanges(t)
+    proxyAddr := startTestProxy(t)
+
+    upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        w.Header().Set("X-Upstream-Expect", r.Header.Get("Expect"))
+        w.Write(body)
+    }))
+    defer upstream.Close()
+
+    proxyURL, _ := url.Parse("http://" + proxyAddr)
+    client := &http.Client{
+        Timeout: 3 * time.Second,
+        Transport: &http.Transport{
+            Proxy:                 http.ProxyURL(proxyURL),
+            ExpectContinueTimeout: 10 * time.Second,
+        },
+    }
+
+    payload := strings.Repeat("x", 64<<10)
+    request, _ := http.NewRequest(http.MethodPost, upstream.URL+"/upload", strings.NewReader(payload))
+    request.Header.Set("Expect", "100-continue")
+
+    response, err := client.Do(request)
+    if err != nil {
+        t.Fatalf("POST с Expect: 100-continue: %v", err)
+    }
+    defer response.Body.Close()
+
+    body, _ := io.ReadAll(response.Body)
+    if response.StatusCode != http.StatusOK || string(body) != payload {
+        t.Fatalf("получен %d, тело %d байт", response.StatusCode, len(body))
+    }
+    if expect := response.Header.Get("X-Upstream-Expect"); expect != "" {
+        t.Errorf("upstream получил Expect: %q", expect)
+    }
+}
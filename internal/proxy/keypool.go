@@ -0,0 +1,120 @@
+package proxy
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "fmt"
+    "strings"
+    "sync"
+)
+
+type KeyType int
+
+const (
+    KeyTypeECDSA KeyType = iota
+    KeyTypeRSA
+)
+
+const defaultKeyPoolSize = 32
+
+func (k KeyType) String() string {
+    switch k {
+    case KeyTypeECDSA:
+        return "ECDSA P-256"
+    case KeyTypeRSA:
+        return "RSA"
+    default:
+        return fmt.Sprintf("KeyType(%d)", int(k))
+    }
+}
+
+func ParseKeyType(name string) (KeyType, error) {
+    switch strings.ToLower(strings.TrimSpace(name)) {
+    case "ecdsa", "ec", "p256":
+        return KeyTypeECDSA, nil
+    case "rsa":
+        return KeyTypeRSA, nil
+    default:
+        return 0, fmt.Errorf("неизвестный тип ключа: %q", name)
+    }
+}
+
+type keyPool struct {
+    keys     chan crypto.Signer
+    generate func() (crypto.Signer, error)
+    stop     chan struct{}
+    start    sync.Once
+    close    sync.Once
+}
+
+func newKeyPool(size int, generate func() (crypto.Signer, error)) *keyPool {
+    return &keyPool{
+        keys:     make(chan crypto.Signer, size),
+        generate: generate,
+        stop:     make(chan struct{}),
+    }
+}
+
+func newKeyGenerator(keyType KeyType, rsaKeySize int) (func() (crypto.Signer, error), error) {
+    switch keyType {
+    case KeyTypeECDSA:
+        return func() (crypto.Signer, error) {
+            key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+            if err != nil {
+                return nil, fmt.Errorf("ошибка генерации ECDSA ключа: %w", err)
+            }
+            return key, nil
+        }, nil
+    case KeyTypeRSA:
+        return func() (crypto.Signer, error) {
+            key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+            if err != nil {
+                return nil, fmt.Errorf("ошибка генерации RSA ключа: %w", err)
+            }
+            return key, nil
+        }, nil
+    default:
+        return nil, fmt.Errorf("неизвестный тип ключа: %s", keyType)
+    }
+}
+
+func (p *keyPool) warmUp() {
+    p.start.Do(func() {
+        go p.fill()
+    })
+}
+
+func (p *keyPool) fill() {
+    for {
+        key, err := p.generate()
+        if err != nil {
+            return
+        }
+
+        select {
+        case p.keys <- key:
+        case <-p.stop:
+            return
+        }
+    }
+}
+
+func (p *keyPool) get() (crypto.Signer, error) {
+    p.warmUp()
+
+    select {
+    case key := <-p.keys:
+        return key, nil
+    default:
+        return p.generate()
+    }
+}
+
+func (p *keyPool) shutdown() {
+    p.close.Do(func() {
+        close(p.stop)
+    })
+}
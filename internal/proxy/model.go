@@ -0,0 +1,415 @@
+package proxy
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "mime/multipart"
+    "net"
+    "net/http"
+    "net/http/httputil"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const (
+    BodyKindForm      = "form"
+    BodyKindMultipart = "multipart"
+    BodyKindJSON      = "json"
+)
+
+type Param struct {
+    Name  string `json:"name"`
+    Value string `json:"value"`
+}
+
+type UploadedFile struct {
+    Field       string `json:"field"`
+    Filename    string `json:"filename"`
+    ContentType string `json:"content_type,omitempty"`
+    Size        int    `json:"size"`
+}
+
+type ParsedRequest struct {
+    Method        string         `json:"method"`
+    Scheme        string         `json:"scheme"`
+    Host          string         `json:"host"`
+    Port          string         `json:"port"`
+    Path          string         `json:"path"`
+    RawQuery      string         `json:"raw_query,omitempty"`
+    Proto         string         `json:"proto"`
+    Query         []Param        `json:"query,omitempty"`
+    Cookies       []Param        `json:"cookies,omitempty"`
+    Headers       HeaderList     `json:"headers"`
+    Body          []byte         `json:"body,omitempty"`
+    BodyTruncated bool           `json:"body_truncated,omitempty"`
+    BodyKind      string         `json:"body_kind,omitempty"`
+    BodyParams    []Param        `json:"body_params,omitempty"`
+    Files         []UploadedFile `json:"files,omitempty"`
+}
+
+type ParsedResponse struct {
+    Proto         string     `json:"proto"`
+    StatusCode    int        `json:"status_code"`
+    Message       string     `json:"message"`
+    Headers       HeaderList `json:"headers"`
+    Cookies       []Param    `json:"cookies,omitempty"`
+    Body          []byte     `json:"body,omitempty"`
+    BodyTruncated bool       `json:"body_truncated,omitempty"`
+    DecodedBody   []byte     `json:"decoded_body,omitempty"`
+    DecodeError   string     `json:"decode_error,omitempty"`
+    BodyKind      string     `json:"body_kind,omitempty"`
+    BodyParams    []Param    `json:"body_params,omitempty"`
+}
+
+func ParseRequest(raw []byte) (*ParsedRequest, error) {
+    reader := bufio.NewReader(bytes.NewReader(raw))
+    rawHeader, err := peekHeaderBlock(reader)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка чтения заголовков запроса: %w", err)
+    }
+    fields := parseHeaderFields(rawHeader)
+
+    request, err := http.ReadRequest(reader)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка разбора запроса: %w", err)
+    }
+    body, err := io.ReadAll(request.Body)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка чтения тела запроса: %w", err)
+    }
+
+    scheme := request.URL.Scheme
+    if scheme == "" {
+        scheme = "http"
+    }
+    host := request.URL.Host
+    if host == "" {
+        host = request.Host
+    }
+
+    target, err := originForm(request.Method, request.RequestURI)
+    if err != nil {
+        return nil, err
+    }
+    return newParsedRequest(request.Method, scheme, host, target, request.Proto, fields, body, false), nil
+}
+
+func (e *CapturedExchange) ParsedRequest() *ParsedRequest {
+    fields := e.RequestFields
+    if fields == nil {
+        fields = headerListFrom(e.RequestHeader)
+    }
+    return newParsedRequest(e.Method, e.Scheme, e.Host, e.Target, e.Proto, fields,
+        e.RequestBody.Data, e.RequestBody.Truncated)
+}
+
+func newParsedRequest(method, scheme, host, target, proto string, fields HeaderList, body []byte, truncated bool) *ParsedRequest {
+    parsed := &ParsedRequest{
+        Method:        method,
+        Scheme:        strings.ToLower(scheme),
+        Proto:         proto,
+        Headers:       fields,
+        Body:          body,
+        BodyTruncated: truncated,
+    }
+
+    parsed.Host, parsed.Port = splitHostPort(host, parsed.Scheme)
+    parsed.Path, parsed.RawQuery, _ = strings.Cut(target, "?")
+    parsed.Query = parseQueryParams(parsed.RawQuery)
+    parsed.Cookies = parseCookieParams(fields)
+    parsed.parseBody()
+    return parsed
+}
+
+func (r *ParsedRequest) parseBody() {
+    r.BodyKind, r.BodyParams, r.Files = parseBodyParams(r.Headers.Get("Content-Type"), r.Body)
+}
+
+func (r *ParsedRequest) Target() string {
+    if r.RawQuery == "" {
+        return r.Path
+    }
+    return r.Path + "?" + r.RawQuery
+}
+
+func (r *ParsedRequest) Authority() string {
+    if r.Port == defaultPort(r.Scheme) {
+        return r.Host
+    }
+    return net.JoinHostPort(r.Host, r.Port)
+}
+
+func (r *ParsedRequest) SetQuery(params []Param) {
+    r.Query = params
+    r.RawQuery = encodeParams(params)
+}
+
+func (r *ParsedRequest) SetBody(body []byte) {
+    r.Body = body
+    r.BodyTruncated = false
+    if r.Headers.Has("Content-Length") {
+        r.Headers = r.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+    }
+    r.parseBody()
+}
+
+func (r *ParsedRequest) SetBodyParams(params []Param) error {
+    switch r.BodyKind {
+    case BodyKindForm, "":
+        r.SetBody([]byte(encodeParams(params)))
+        if !r.Headers.Has("Content-Type") {
+            r.Headers = r.Headers.Set("Content-Type", "application/x-www-form-urlencoded")
+            r.parseBody()
+        }
+        if !r.Headers.Has("Content-Length") {
+            r.Headers = r.Headers.Set("Content-Length", strconv.Itoa(len(r.Body)))
+        }
+        return nil
+    default:
+        return fmt.Errorf("изменение параметров тела %s не поддерживается, используйте замену тела", r.BodyKind)
+    }
+}
+
+func (r *ParsedRequest) Raw() []byte {
+    builder := strings.Builder{}
+    builder.WriteString(fmt.Sprintf("%s %s %s\r\n", r.Method, r.Target(), protoOrDefault(r.Proto)))
+    r.Headers.writeTo(&builder)
+    builder.WriteString("\r\n")
+    return appendRawBody([]byte(builder.String()), r.Headers, r.Body)
+}
+
+func ParseResponse(raw []byte) (*ParsedResponse, error) {
+    reader := bufio.NewReader(bytes.NewReader(raw))
+    rawHeader, err := peekHeaderBlock(reader)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка чтения заголовков ответа: %w", err)
+    }
+    fields := parseHeaderFields(rawHeader)
+
+    response, err := http.ReadResponse(reader, nil)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка разбора ответа: %w", err)
+    }
+    body, err := io.ReadAll(response.Body)
+    if err != nil {
+        return nil, fmt.Errorf("ошибка чтения тела ответа: %w", err)
+    }
+
+    captured := CapturedBody{Data: body, Size: int64(len(body))}
+    captured.decode(fields.Header())
+    return newParsedResponse(response.Proto, response.StatusCode, response.Status, fields, &captured), nil
+}
+
+func (e *CapturedExchange) ParsedResponse() *ParsedResponse {
+    if e.StatusCode == 0 {
+        return nil
+    }
+
+    fields := e.ResponseFields
+    if fields == nil {
+        fields = headerListFrom(e.ResponseHeader)
+    }
+    return newParsedResponse(e.ResponseProto, e.StatusCode, e.Status, fields, &e.ResponseBody)
+}
+
+func newParsedResponse(proto string, code int, status string, fields HeaderList, body *CapturedBody) *ParsedResponse {
+    parsed := &ParsedResponse{
+        Proto:         proto,
+        StatusCode:    code,
+        Message:       strings.TrimPrefix(status, strconv.Itoa(code)+" "),
+        Headers:       fields,
+        Body:          body.Data,
+        BodyTruncated: body.Truncated,
+        DecodedBody:   body.Decoded,
+        DecodeError:   body.DecodeError,
+    }
+
+    for _, value := range fields.Values("Set-Cookie") {
+        pair, _, _ := strings.Cut(value, ";")
+        name, value, _ := strings.Cut(pair, "=")
+        parsed.Cookies = append(parsed.Cookies, Param{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+    }
+
+    parsed.BodyKind, parsed.BodyParams, _ = parseBodyParams(fields.Get("Content-Type"), body.Readable())
+    return parsed
+}
+
+func (r *ParsedResponse) Raw() []byte {
+    builder := strings.Builder{}
+    builder.WriteString(fmt.Sprintf("%s %d %s\r\n", protoOrDefault(r.Proto), r.StatusCode, r.Message))
+    r.Headers.writeTo(&builder)
+    builder.WriteString("\r\n")
+    return appendRawBody([]byte(builder.String()), r.Headers, r.Body)
+}
+
+func appendRawBody(head []byte, headers HeaderList, body []byte) []byte {
+    if !strings.EqualFold(strings.TrimSpace(headers.Get("Transfer-Encoding")), "chunked") {
+        return append(head, body...)
+    }
+
+    buffer := bytes.NewBuffer(head)
+    chunkedWriter := httputil.NewChunkedWriter(buffer)
+    chunkedWriter.Write(body)
+    chunkedWriter.Close()
+    buffer.WriteString("\r\n")
+    return buffer.Bytes()
+}
+
+func protoOrDefault(proto string) string {
+    if proto == "" {
+        return "HTTP/1.1"
+    }
+    return proto
+}
+
+func defaultPort(scheme string) string {
+    if scheme == "https" {
+        return "443"
+    }
+    return "80"
+}
+
+func splitHostPort(authority, scheme string) (string, string) {
+    host, port, err := net.SplitHostPort(authority)
+    if err != nil {
+        return strings.Trim(authority, "[]"), defaultPort(scheme)
+    }
+    return host, port
+}
+
+func headerListFrom(header http.Header) HeaderList {
+    return HeaderList(nil).merge(header)
+}
+
+func parseQueryParams(rawQuery string) []Param {
+    var params []Param
+    for _, pair := range strings.FieldsFunc(rawQuery, func(r rune) bool { return r == '&' || r == ';' }) {
+        name, value, _ := strings.Cut(pair, "=")
+        params = append(params, Param{Name: unescapeParam(name), Value: unescapeParam(value)})
+    }
+    return params
+}
+
+func unescapeParam(value string) string {
+    if unescaped, err := url.QueryUnescape(value); err == nil {
+        return unescaped
+    }
+    return value
+}
+
+func encodeParams(params []Param) string {
+    pairs := make([]string, 0, len(params))
+    for _, param := range params {
+        pairs = append(pairs, url.QueryEscape(param.Name)+"="+url.QueryEscape(param.Value))
+    }
+    return strings.Join(pairs, "&")
+}
+
+func parseCookieParams(fields HeaderList) []Param {
+    var cookies []Param
+    for _, header := range fields.Values("Cookie") {
+        for _, pair := range strings.Split(header, ";") {
+            if pair = strings.TrimSpace(pair); pair == "" {
+                continue
+            }
+            name, value, _ := strings.Cut(pair, "=")
+            cookies = append(cookies, Param{Name: name, Value: value})
+        }
+    }
+    return cookies
+}
+
+func parseBodyParams(contentType string, body []byte) (string, []Param, []UploadedFile) {
+    if len(body) == 0 {
+        return "", nil, nil
+    }
+
+    mediaType, mediaParams, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return "", nil, nil
+    }
+
+    switch {
+    case mediaType == "application/x-www-form-urlencoded":
+        return BodyKindForm, parseQueryParams(string(body)), nil
+    case mediaType == "multipart/form-data":
+        params, files := parseMultipartParams(body, mediaParams["boundary"])
+        return BodyKindMultipart, params, files
+    case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+        decoder := json.NewDecoder(bytes.NewReader(body))
+        decoder.UseNumber()
+        var value any
+        if err := decoder.Decode(&value); err != nil {
+            return "", nil, nil
+        }
+        return BodyKindJSON, flattenJSON("", value, nil), nil
+    }
+    return "", nil, nil
+}
+
+func parseMultipartParams(body []byte, boundary string) ([]Param, []UploadedFile) {
+    var (
+        params []Param
+        files  []UploadedFile
+    )
+    if boundary == "" {
+        return nil, nil
+    }
+
+    reader := multipart.NewReader(bytes.NewReader(body), boundary)
+    for {
+        part, err := reader.NextRawPart()
+        if err != nil {
+            return params, files
+        }
+
+        data, err := io.ReadAll(part)
+        if err != nil {
+            return params, files
+        }
+
+        if part.FileName() != "" {
+            files = append(files, UploadedFile{
+                Field:       part.FormName(),
+                Filename:    part.FileName(),
+                ContentType: part.Header.Get("Content-Type"),
+                Size:        len(data),
+            })
+            continue
+        }
+        params = append(params, Param{Name: part.FormName(), Value: string(data)})
+    }
+}
+
+func flattenJSON(prefix string, value any, params []Param) []Param {
+    switch typed := value.(type) {
+    case map[string]any:
+        keys := make([]string, 0, len(typed))
+        for key := range typed {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        for _, key := range keys {
+            name := key
+            if prefix != "" {
+                name = prefix + "." + key
+            }
+            params = flattenJSON(name, typed[key], params)
+        }
+    case []any:
+        for i, item := range typed {
+            params = flattenJSON(fmt.Sprintf("%s[%d]", prefix, i), item, params)
+        }
+    case nil:
+        params = append(params, Param{Name: prefix, Value: "null"})
+    default:
+        params = append(params, Param{Name: prefix, Value: fmt.Sprint(typed)})
+    }
+    return params
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+    "bytes"
+    "reflect"
+    "strconv"
+    "testing"
+)
+
+const testMultipartBody = "--XyZ\r\n" +
+    "Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+    "отчёт\r\n" +
+    "--XyZ\r\n" +
+    "Content-Disposition: form-data; name=\"upload\"; filename=\"report.csv\"\r\n" +
+    "Content-Type: text/csv\r\n\r\n" +
+    "a,b\n1,2\r\n" +
+    "--XyZ--\r\n"
+
+func TestParseRequestRoundTrip(t *testing.T) {
+    tests := []struct {
+        name       string
+        raw        string
+        path       string
+        query      []Param
+        cookies    []Param
+        body       string
+        bodyKind   string
+        bodyParams []Param
+        files      []UploadedFile
+    }{
+        {
+            name: "Content-Length и form",
+            raw: "POST /login?next=%2Fhome&lang=ru HTTP/1.1\r\n" +
+                "Host: example.com\r\n" +
+                "Content-Type: application/x-www-form-urlencoded\r\n" +
+                "Content-Length: 23\r\n\r\n" +
+                "user=admin&pass=a%26b+c",
+            path:       "/login",
+            query:      []Param{{Name: "next", Value: "/home"}, {Name: "lang", Value: "ru"}},
+            body:       "user=admin&pass=a%26b+c",
+            bodyKind:   BodyKindForm,
+            bodyParams: []Param{{Name: "user", Value: "admin"}, {Name: "pass", Value: "a&b c"}},
+        },
+        {
+            name: "chunked",
+            raw: "PUT /upload HTTP/1.1\r\n" +
+                "Host: example.com:8080\r\n" +
+                "Transfer-Encoding: chunked\r\n\r\n" +
+                "b\r\nhello world\r\n0\r\n\r\n",
+            path: "/upload",
+            body: "hello world",
+        },
+        {
+            name: "повторяющиеся заголовки",
+            raw: "GET / HTTP/1.1\r\n" +
+                "Host: example.com\r\n" +
+                "Cookie: session=abc; theme=dark\r\n" +
+                "X-Trace: first\r\n" +
+                "cookie: lang=ru\r\n" +
+                "X-Trace: second\r\n\r\n",
+            path:    "/",
+            cookies: []Param{{Name: "session", Value: "abc"}, {Name: "theme", Value: "dark"}, {Name: "lang", Value: "ru"}},
+        },
+        {
+            name: "multipart с файлом",
+            raw: "POST /form HTTP/1.1\r\n" +
+                "Host: example.com\r\n" +
+                "Content-Type: multipart/form-data; boundary=XyZ\r\n" +
+                "Content-Length: " + strconv.Itoa(len(testMultipartBody)) + "\r\n\r\n" +
+                testMultipartBody,
+            path:       "/form",
+            body:       testMultipartBody,
+            bodyKind:   BodyKindMultipart,
+            bodyParams: []Param{{Name: "title", Value: "отчёт"}},
+            files:      []UploadedFile{{Field: "upload", Filename: "report.csv", ContentType: "text/csv", Size: 7}},
+        },
+        {
+            name: "вложенный JSON",
+            raw: "POST /api HTTP/1.1\r\n" +
+                "Host: example.com\r\n" +
+                "Content-Type: application/json\r\n" +
+                "Content-Length: 60\r\n\r\n" +
+                `{"user":{"name":"ivan","roles":["admin",{"id":7}]},"x":null}`,
+            path:     "/api",
+            body:     `{"user":{"name":"ivan","roles":["admin",{"id":7}]},"x":null}`,
+            bodyKind: BodyKindJSON,
+            bodyParams: []Param{
+                {Name: "user.name", Value: "ivan"},
+                {Name: "user.roles[0]", Value: "admin"},
+                {Name: "user.roles[1].id", Value: "7"},
+                {Name: "x", Value: "null"},
+            },
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            parsed, err := ParseRequest([]byte(test.raw))
+            if err != nil {
+                t.Fatalf("ParseRequest: %v", err)
+            }
+
+            if raw := parsed.Raw(); !bytes.Equal(raw, []byte(test.raw)) {
+                t.Errorf("Raw() не совпадает с исходным запросом:\n%q\nожидалось\n%q", raw, test.raw)
+            }
+            if parsed.Path != test.path {
+                t.Errorf("Path = %q, ожидалось %q", parsed.Path, test.path)
+            }
+            if string(parsed.Body) != test.body {
+                t.Errorf("Body = %q, ожидалось %q", parsed.Body, test.body)
+            }
+            if parsed.BodyKind != test.bodyKind {
+                t.Errorf("BodyKind = %q, ожидалось %q", parsed.BodyKind, test.bodyKind)
+            }
+            assertParams(t, "Query", parsed.Query, test.query)
+            assertParams(t, "Cookies", parsed.Cookies, test.cookies)
+            assertParams(t, "BodyParams", parsed.BodyParams, test.bodyParams)
+            if !reflect.DeepEqual(parsed.Files, test.files) {
+                t.Errorf("Files = %+v, ожидалось %+v", parsed.Files, test.files)
+            }
+        })
+    }
+}
+
+func TestParseRequestDuplicateHeadersKeepOrder(t *testing.T) {
+    raw := "GET / HTTP/1.1\r\nHost: a\r\nX-Trace: first\r\nAccept: */*\r\nX-Trace: second\r\n\r\n"
+    parsed, err := ParseRequest([]byte(raw))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := HeaderList{{Name: "Host", Value: "a"}, {Name: "X-Trace", Value: "first"}, {Name: "Accept", Value: "*/*"}, {Name: "X-Trace", Value: "second"}}
+    if !reflect.DeepEqual(parsed.Headers, want) {
+        t.Errorf("Headers = %+v, ожидалось %+v", parsed.Headers, want)
+    }
+}
+
+func TestParseResponseRoundTrip(t *testing.T) {
+    tests := []struct {
+        name       string
+        raw        string
+        statusCode int
+        message    string
+        cookies    []Param
+        body       string
+        bodyParams []Param
+    }{
+        {
+            name: "Content-Length",
+            raw: "HTTP/1.1 404 Not Found\r\n" +
+                "Content-Type: text/plain\r\n" +
+                "Content-Length: 9\r\n\r\n" +
+                "not found",
+            statusCode: 404,
+            message:    "Not Found",
+            body:       "not found",
+        },
+        {
+            name: "chunked JSON и повторяющиеся Set-Cookie",
+            raw: "HTTP/1.1 200 OK\r\n" +
+                "Set-Cookie: session=abc; Path=/; HttpOnly\r\n" +
+                "Content-Type: application/json\r\n" +
+                "Set-Cookie: theme=dark\r\n" +
+                "Transfer-Encoding: chunked\r\n\r\n" +
+                "f\r\n{\"a\":{\"b\":[1]}}\r\n0\r\n\r\n",
+            statusCode: 200,
+            message:    "OK",
+            cookies:    []Param{{Name: "session", Value: "abc"}, {Name: "theme", Value: "dark"}},
+            body:       `{"a":{"b":[1]}}`,
+            bodyParams: []Param{{Name: "a.b[0]", Value: "1"}},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            parsed, err := ParseResponse([]byte(test.raw))
+            if err != nil {
+                t.Fatalf("ParseResponse: %v", err)
+            }
+
+            if raw := parsed.Raw(); !bytes.Equal(raw, []byte(test.raw)) {
+                t.Errorf("Raw() не совпадает с исходным ответом:\n%q\nожидалось\n%q", raw, test.raw)
+            }
+            if parsed.StatusCode != test.statusCode || parsed.Message != test.message {
+                t.Errorf("статус = %d %q, ожидалось %d %q", parsed.StatusCode, parsed.Message, test.statusCode, test.message)
+            }
+            if string(parsed.Body) != test.body {
+                t.Errorf("Body = %q, ожидалось %q", parsed.Body, test.body)
+            }
+            assertParams(t, "Cookies", parsed.Cookies, test.cookies)
+            assertParams(t, "BodyParams", parsed.BodyParams, test.bodyParams)
+        })
+    }
+}
+
+func TestParsedRequestEditsKeepFraming(t *testing.T) {
+    raw := "POST /submit?a=1 HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 3\r\n\r\nx=1"
+    parsed, err := ParseRequest([]byte(raw))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    parsed.SetQuery([]Param{{Name: "q", Value: "a b"}})
+    if err := parsed.SetBodyParams([]Param{{Name: "name", Value: "значение"}}); err != nil {
+        t.Fatal(err)
+    }
+
+    want := "POST /submit?q=a+b HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 53\r\n\r\n" +
+        "name=%D0%B7%D0%BD%D0%B0%D1%87%D0%B5%D0%BD%D0%B8%D0%B5"
+    if raw := string(parsed.Raw()); raw != want {
+        t.Errorf("Raw() после правок:\n%q\nожидалось\n%q", raw, want)
+    }
+
+    reparsed, err := ParseRequest(parsed.Raw())
+    if err != nil {
+        t.Fatalf("повторный разбор: %v", err)
+    }
+    assertParams(t, "BodyParams", reparsed.BodyParams, []Param{{Name: "name", Value: "значение"}})
+}
+
+func assertParams(t *testing.T, name string, got, want []Param) {
+    t.Helper()
+    if len(got) == 0 && len(want) == 0 {
+        return
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("%s = %+v, ожидалось %+v", name, got, want)
+    }
+}
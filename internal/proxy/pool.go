@@ -0,0 +1,219 @@
+package proxy
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+)
+
+const (
+    defaultMaxConnsPerHost = 16
+    defaultIdleTimeout     = 90 * time.Second
+    defaultPoolWaitTimeout = 30 * time.Second
+)
+
+var errUpstreamPoolExhausted = errors.New("все соединения к upstream заняты")
+
+type upstreamTarget struct {
+    scheme     string
+    address    string
+    serverName string
+}
+
+type upstreamConn struct {
+    conn      net.Conn
+    reader    *bufio.Reader
+    key       string
+    tls       *TLSDetails
+    reused    bool
+    idleSince time.Time
+}
+
+type upstreamPool struct {
+    mutex       sync.Mutex
+    available   *sync.Cond
+    idle        map[string][]*upstreamConn
+    open        map[string]int
+    maxPerHost  int
+    idleTimeout time.Duration
+    waitTimeout time.Duration
+    janitor     sync.Once
+}
+
+var connectionPool = newUpstreamPool(defaultMaxConnsPerHost, defaultIdleTimeout)
+
+func newUpstreamPool(maxPerHost int, idleTimeout time.Duration) *upstreamPool {
+    pool := &upstreamPool{
+        idle:        make(map[string][]*upstreamConn),
+        open:        make(map[string]int),
+        maxPerHost:  maxPerHost,
+        idleTimeout: idleTimeout,
+        waitTimeout: defaultPoolWaitTimeout,
+    }
+    pool.available = sync.NewCond(&pool.mutex)
+    return pool
+}
+
+func SetUpstreamPoolLimits(maxPerHost int, idleTimeout time.Duration) {
+    connectionPool.mutex.Lock()
+    defer connectionPool.mutex.Unlock()
+
+    if maxPerHost > 0 {
+        connectionPool.maxPerHost = maxPerHost
+    }
+    if idleTimeout > 0 {
+        connectionPool.idleTimeout = idleTimeout
+    }
+    connectionPool.available.Broadcast()
+}
+
+func SetUpstreamPoolWaitTimeout(timeout time.Duration) {
+    connectionPool.mutex.Lock()
+    defer connectionPool.mutex.Unlock()
+
+    if timeout > 0 {
+        connectionPool.waitTimeout = timeout
+    }
+    connectionPool.available.Broadcast()
+}
+
+func (t upstreamTarget) key() string {
+    return t.scheme + "://" + t.address + "#" + t.serverName
+}
+
+func (p *upstreamPool) get(target upstreamTarget, dial func() (*upstreamConn, error)) (*upstreamConn, error) {
+    key := target.key()
+
+    var deadline time.Time
+    p.mutex.Lock()
+    for {
+        if conn := p.takeIdle(key); conn != nil {
+            p.mutex.Unlock()
+            conn.reused = true
+            return conn, nil
+        }
+        if p.open[key] < p.maxPerHost {
+            p.open[key]++
+            break
+        }
+
+        if deadline.IsZero() {
+            deadline = time.Now().Add(p.waitTimeout)
+            timer := time.AfterFunc(p.waitTimeout, func() {
+                p.mutex.Lock()
+                defer p.mutex.Unlock()
+                p.available.Broadcast()
+            })
+            defer timer.Stop()
+        } else if !time.Now().Before(deadline) {
+            p.mutex.Unlock()
+            return nil, fmt.Errorf("%w: %s", errUpstreamPoolExhausted, target.address)
+        }
+        p.available.Wait()
+    }
+    p.mutex.Unlock()
+
+    conn, err := dial()
+    if err != nil {
+        p.release(key)
+        return nil, err
+    }
+
+    conn.key = key
+    return conn, nil
+}
+
+func (p *upstreamPool) takeIdle(key string) *upstreamConn {
+    now := time.Now()
+    for conns := p.idle[key]; len(conns) > 0; conns = p.idle[key] {
+        conn := conns[len(conns)-1]
+        p.idle[key] = conns[:len(conns)-1]
+
+        if now.Sub(conn.idleSince) < p.idleTimeout {
+            return conn
+        }
+        conn.conn.Close()
+        p.open[key]--
+    }
+    return nil
+}
+
+func (p *upstreamPool) put(conn *upstreamConn) {
+    if conn.key == "" {
+        conn.conn.Close()
+        return
+    }
+    if conn.reader.Buffered() > 0 {
+        p.discard(conn)
+        return
+    }
+
+    p.janitor.Do(func() {
+        go p.evictIdle()
+    })
+
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    conn.idleSince = time.Now()
+    p.idle[conn.key] = append(p.idle[conn.key], conn)
+    p.available.Broadcast()
+}
+
+func (p *upstreamPool) discard(conn *upstreamConn) {
+    conn.conn.Close()
+    p.detach(conn)
+}
+
+func (p *upstreamPool) detach(conn *upstreamConn) {
+    if conn.key == "" {
+        return
+    }
+    p.release(conn.key)
+    conn.key = ""
+}
+
+func (p *upstreamPool) release(key string) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    p.open[key]--
+    if p.open[key] <= 0 {
+        delete(p.open, key)
+    }
+    p.available.Broadcast()
+}
+
+func (p *upstreamPool) evictIdle() {
+    for {
+        p.mutex.Lock()
+        interval := p.idleTimeout / 2
+        now := time.Now()
+        for key, conns := range p.idle {
+            fresh := conns[:0]
+            for _, conn := range conns {
+                if now.Sub(conn.idleSince) < p.idleTimeout {
+                    fresh = append(fresh, conn)
+                    continue
+                }
+                conn.conn.Close()
+                p.open[key]--
+            }
+            if len(fresh) == 0 {
+                delete(p.idle, key)
+            } else {
+                p.idle[key] = fresh
+            }
+            if p.open[key] <= 0 {
+                delete(p.open, key)
+            }
+        }
+        p.available.Broadcast()
+        p.mutex.Unlock()
+
+        time.Sleep(interval)
+    }
+}
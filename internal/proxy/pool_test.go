@@ -0,0 +1,91 @@
+package proxy
+
+import (
+    "bufio"
+    "errors"
+    "net"
+    "testing"
+    "time"
+)
+
+func pipeDialer(t *testing.T) func() (*upstreamConn, error) {
+    return func() (*upstreamConn, error) {
+        client, server := net.Pipe()
+        t.Cleanup(func() {
+            client.Close()
+            server.Close()
+        })
+        return &upstreamConn{conn: client, reader: bufio.NewReader(client)}, nil
+    }
+}
+
+func TestUpstreamPoolWaitTimeout(t *testing.T) {
+    pool := newUpstreamPool(1, time.Minute)
+    pool.waitTimeout = 50 * time.Millisecond
+    target := upstreamTarget{scheme: "http", address: "example.com:80"}
+
+    first, err := pool.get(target, pipeDialer(t))
+    if err != nil {
+        t.Fatalf("первое соединение: %v", err)
+    }
+
+    started := time.Now()
+    if _, err := pool.get(target, pipeDialer(t)); !errors.Is(err, errUpstreamPoolExhausted) {
+        t.Fatalf("ожидалась ошибка исчерпания пула, получено %v", err)
+    }
+    if elapsed := time.Since(started); elapsed < pool.waitTimeout {
+        t.Fatalf("ожидание завершилось раньше таймаута: %v", elapsed)
+    }
+
+    released := make(chan error, 1)
+    pool.waitTimeout = time.Minute
+    go func() {
+        conn, err := pool.get(target, pipeDialer(t))
+        if err == nil {
+            pool.discard(conn)
+        }
+        released <- err
+    }()
+    time.Sleep(20 * time.Millisecond)
+    pool.discard(first)
+
+    select {
+    case err := <-released:
+        if err != nil {
+            t.Fatalf("соединение после освобождения: %v", err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("ожидающий запрос не получил освободившийся слот")
+    }
+}
+
+func TestUpstreamPoolDetach(t *testing.T) {
+    pool := newUpstreamPool(1, time.Minute)
+    pool.waitTimeout = 50 * time.Millisecond
+    target := upstreamTarget{scheme: "http", address: "example.com:80"}
+
+    upgraded, err := pool.get(target, pipeDialer(t))
+    if err != nil {
+        t.Fatalf("первое соединение: %v", err)
+    }
+    pool.detach(upgraded)
+
+    next, err := pool.get(target, pipeDialer(t))
+    if err != nil {
+        t.Fatalf("отсоединённое соединение продолжает занимать слот: %v", err)
+    }
+
+    pool.discard(upgraded)
+    pool.put(upgraded)
+    if open := pool.open[target.key()]; open != 1 {
+        t.Fatalf("open = %d после повторного освобождения отсоединённого соединения, ожидалось 1", open)
+    }
+    if idle := len(pool.idle[target.key()]); idle != 0 {
+        t.Fatalf("отсоединённое соединение вернулось в пул: idle = %d", idle)
+    }
+
+    pool.discard(next)
+    if _, ok := pool.open[target.key()]; ok {
+        t.Fatal("слот не освобождён после закрытия соединения")
+    }
+}
@@ -0,0 +1,328 @@
+package proxy
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const maxDiffLines = 2000
+
+var (
+    errInvalidRepeatEdit = errors.New("некорректные изменения запроса")
+    errTruncatedRequest  = errors.New("тело исходного запроса сохранено не полностью")
+)
+
+type RepeatEdits struct {
+    Method        string        `json:"method,omitempty"`
+    Path          string        `json:"path,omitempty"`
+    RemoveHeaders []string      `json:"remove_headers,omitempty"`
+    SetHeaders    []HeaderField `json:"set_headers,omitempty"`
+    Query         []Param       `json:"query,omitempty"`
+    BodyParams    []Param       `json:"body_params,omitempty"`
+    Body          *string       `json:"body,omitempty"`
+}
+
+type RepeatResult struct {
+    OriginalID uint64          `json:"original_id"`
+    RepeatID   uint64          `json:"repeat_id"`
+    Request    *ParsedRequest  `json:"request"`
+    Response   *ParsedResponse `json:"response,omitempty"`
+    Error      string          `json:"error,omitempty"`
+    Diff       ResponseDiff    `json:"diff"`
+}
+
+type ResponseDiff struct {
+    Status  *ValueChange   `json:"status,omitempty"`
+    Headers []HeaderChange `json:"headers,omitempty"`
+    Body    BodyDiff       `json:"body"`
+}
+
+type ValueChange struct {
+    Original string `json:"original"`
+    Repeated string `json:"repeated"`
+}
+
+type HeaderChange struct {
+    Name     string   `json:"name"`
+    Original []string `json:"original,omitempty"`
+    Repeated []string `json:"repeated,omitempty"`
+}
+
+type BodyDiff struct {
+    Changed      bool          `json:"changed"`
+    OriginalSize int64         `json:"original_size"`
+    RepeatedSize int64         `json:"repeated_size"`
+    Params       []ParamChange `json:"params,omitempty"`
+    Lines        []LineChange  `json:"lines,omitempty"`
+}
+
+type ParamChange struct {
+    Name     string  `json:"name"`
+    Original *string `json:"original,omitempty"`
+    Repeated *string `json:"repeated,omitempty"`
+}
+
+type LineChange struct {
+    Op   string `json:"op"`
+    Line int    `json:"line"`
+    Text string `json:"text"`
+}
+
+func RepeatRequest(id uint64, edits RepeatEdits) (*RepeatResult, error) {
+    original, err := GetHistoryEntry(id)
+    if err != nil {
+        return nil, err
+    }
+
+    parsed := original.ParsedRequest()
+    if err := edits.apply(parsed); err != nil {
+        return nil, err
+    }
+    if parsed.BodyTruncated {
+        return nil, fmt.Errorf("%w: запрос %d", errTruncatedRequest, id)
+    }
+
+    request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(parsed.Raw())))
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", errInvalidRepeatEdit, err)
+    }
+    request.URL.Scheme = parsed.Scheme
+    request.URL.Host = parsed.Authority()
+
+    exchange := &CapturedExchange{
+        ConnectionID: nextConnectionID(),
+        ClientAddr:   "repeater",
+        Scheme:       parsed.Scheme,
+        Host:         parsed.Authority(),
+        StartedAt:    time.Now(),
+    }
+    err = repeatExchange(exchange, request, parsed.Headers)
+    finishExchange(exchange, err)
+    logExchange(exchange)
+
+    entry := &HistoryEntry{RepeatOf: original.ID, CapturedExchange: *exchange}
+    if saveErr := saveHistoryEntry(entry); saveErr != nil {
+        return nil, saveErr
+    }
+
+    result := &RepeatResult{
+        OriginalID: original.ID,
+        RepeatID:   entry.ID,
+        Request:    entry.ParsedRequest(),
+        Response:   entry.ParsedResponse(),
+        Error:      entry.Error,
+        Diff:       diffResponses(&original.CapturedExchange, &entry.CapturedExchange),
+    }
+    return result, nil
+}
+
+func repeatExchange(exchange *CapturedExchange, request *http.Request, fields HeaderList) error {
+    session := newClientSession(exchange.ConnectionID, nil, nil)
+
+    exchange.recordRequest(request, fields)
+    runRequestHooks(request)
+    request.Body = captureBody(request.Body, &exchange.RequestBody)
+
+    upstream, response, responseFields, err := session.forwardHTTPRequest(request, fields)
+    if err != nil {
+        return err
+    }
+    exchange.TLS = upstream.tls
+    exchange.recordResponse(response, responseFields)
+
+    runResponseHooks(response)
+    response.Body = captureBody(response.Body, &exchange.ResponseBody)
+
+    _, err = io.Copy(io.Discard, response.Body)
+    response.Body.Close()
+    if err != nil || response.Close || response.StatusCode == http.StatusSwitchingProtocols {
+        connectionPool.discard(upstream)
+    } else {
+        connectionPool.put(upstream)
+    }
+    return err
+}
+
+func (e RepeatEdits) apply(request *ParsedRequest) error {
+    if e.Method != "" {
+        request.Method = strings.ToUpper(e.Method)
+    }
+    if e.Path != "" {
+        if !strings.HasPrefix(e.Path, "/") {
+            return fmt.Errorf("%w: путь должен начинаться с /: %q", errInvalidRepeatEdit, e.Path)
+        }
+        request.Path = e.Path
+    }
+
+    for _, name := range e.RemoveHeaders {
+        request.Headers = request.Headers.Del(name)
+    }
+    for _, field := range e.SetHeaders {
+        if field.Name == "" || strings.ContainsAny(field.Name+field.Value, "\r\n") {
+            return fmt.Errorf("%w: недопустимый заголовок %q", errInvalidRepeatEdit, field.Name)
+        }
+        request.Headers = request.Headers.Set(field.Name, field.Value)
+    }
+    if len(e.SetHeaders) > 0 || len(e.RemoveHeaders) > 0 {
+        request.Cookies = parseCookieParams(request.Headers)
+    }
+
+    if e.Query != nil {
+        request.SetQuery(e.Query)
+    }
+    if e.BodyParams != nil {
+        if err := request.SetBodyParams(e.BodyParams); err != nil {
+            return fmt.Errorf("%w: %v", errInvalidRepeatEdit, err)
+        }
+    }
+    if e.Body != nil {
+        request.SetBody([]byte(*e.Body))
+        if !request.Headers.Has("Content-Length") && !request.Headers.Has("Transfer-Encoding") {
+            request.Headers = request.Headers.Set("Content-Length", strconv.Itoa(len(request.Body)))
+        }
+    }
+    return nil
+}
+
+func diffResponses(original, repeated *CapturedExchange) ResponseDiff {
+    diff := ResponseDiff{}
+
+    if original.StatusCode != repeated.StatusCode {
+        diff.Status = &ValueChange{Original: original.Status, Repeated: repeated.Status}
+    }
+    diff.Headers = diffHeaders(original.ResponseHeader, repeated.ResponseHeader)
+    diff.Body = diffBodies(original, repeated)
+    return diff
+}
+
+func diffHeaders(original, repeated http.Header) []HeaderChange {
+    names := map[string]bool{}
+    for name := range original {
+        names[http.CanonicalHeaderKey(name)] = true
+    }
+    for name := range repeated {
+        names[http.CanonicalHeaderKey(name)] = true
+    }
+
+    sorted := make([]string, 0, len(names))
+    for name := range names {
+        sorted = append(sorted, name)
+    }
+    sort.Strings(sorted)
+
+    var changes []HeaderChange
+    for _, name := range sorted {
+        before, after := original.Values(name), repeated.Values(name)
+        if !slices.Equal(before, after) {
+            changes = append(changes, HeaderChange{Name: name, Original: before, Repeated: after})
+        }
+    }
+    return changes
+}
+
+func diffBodies(original, repeated *CapturedExchange) BodyDiff {
+    before, after := original.ResponseBody.Readable(), repeated.ResponseBody.Readable()
+    diff := BodyDiff{
+        Changed:      !bytes.Equal(before, after),
+        OriginalSize: original.ResponseBody.Size,
+        RepeatedSize: repeated.ResponseBody.Size,
+    }
+    if !diff.Changed {
+        return diff
+    }
+
+    originalParsed, repeatedParsed := original.ParsedResponse(), repeated.ParsedResponse()
+    if originalParsed != nil && repeatedParsed != nil &&
+        originalParsed.BodyKind != "" && originalParsed.BodyKind == repeatedParsed.BodyKind {
+        diff.Params = diffParams(originalParsed.BodyParams, repeatedParsed.BodyParams)
+        return diff
+    }
+
+    diff.Lines = diffLines(strings.Split(string(before), "\n"), strings.Split(string(after), "\n"))
+    return diff
+}
+
+func diffParams(original, repeated []Param) []ParamChange {
+    before := map[string]string{}
+    var order []string
+    for _, param := range original {
+        if _, seen := before[param.Name]; !seen {
+            order = append(order, param.Name)
+        }
+        before[param.Name] = param.Value
+    }
+
+    after := map[string]string{}
+    for _, param := range repeated {
+        if _, seen := after[param.Name]; !seen {
+            if _, known := before[param.Name]; !known {
+                order = append(order, param.Name)
+            }
+        }
+        after[param.Name] = param.Value
+    }
+
+    var changes []ParamChange
+    for _, name := range order {
+        oldValue, hadOld := before[name]
+        newValue, hasNew := after[name]
+        if hadOld == hasNew && oldValue == newValue {
+            continue
+        }
+
+        change := ParamChange{Name: name}
+        if hadOld {
+            change.Original = &oldValue
+        }
+        if hasNew {
+            change.Repeated = &newValue
+        }
+        changes = append(changes, change)
+    }
+    return changes
+}
+
+func diffLines(original, repeated []string) []LineChange {
+    if len(original) > maxDiffLines || len(repeated) > maxDiffLines {
+        return nil
+    }
+
+    common := make([][]int, len(original)+1)
+    for i := range common {
+        common[i] = make([]int, len(repeated)+1)
+    }
+    for i := len(original) - 1; i >= 0; i-- {
+        for j := len(repeated) - 1; j >= 0; j-- {
+            if original[i] == repeated[j] {
+                common[i][j] = common[i+1][j+1] + 1
+            } else {
+                common[i][j] = max(common[i+1][j], common[i][j+1])
+            }
+        }
+    }
+
+    var changes []LineChange
+    i, j := 0, 0
+    for i < len(original) || j < len(repeated) {
+        switch {
+        case i < len(original) && j < len(repeated) && original[i] == repeated[j]:
+            i, j = i+1, j+1
+        case j < len(repeated) && (i == len(original) || common[i][j+1] >= common[i+1][j]):
+            changes = append(changes, LineChange{Op: "+", Line: j + 1, Text: repeated[j]})
+            j++
+        default:
+            changes = append(changes, LineChange{Op: "-", Line: i + 1, Text: original[i]})
+            i++
+        }
+    }
+    return changes
+}
@@ -0,0 +1,287 @@
+package proxy
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func useTestHistory(t *testing.T) *FileHistory {
+    t.Helper()
+
+    history, err := NewFileHistory(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    historyMutex.RLock()
+    previous := historyStorage
+    historyMutex.RUnlock()
+
+    UseHistoryStorage(history)
+    t.Cleanup(func() { UseHistoryStorage(previous) })
+    return history
+}
+
+func startProfileServer(t *testing.T) *url.URL {
+    t.Helper()
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        role, status := "guest", http.StatusOK
+        if r.Header.Get("X-Role") == "admin" {
+            role, status = "admin", http.StatusCreated
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("X-Version", "2")
+        w.WriteHeader(status)
+        fmt.Fprintf(w, `{"role":%q,"user":%q}`, role, r.URL.Query().Get("user"))
+    }))
+    t.Cleanup(server.Close)
+
+    serverURL, _ := url.Parse(server.URL)
+    return serverURL
+}
+
+func saveOriginalProfile(t *testing.T, history *FileHistory, serverURL *url.URL) *HistoryEntry {
+    t.Helper()
+
+    body := []byte(`{"role":"guest","user":"ivan"}`)
+    entry := &HistoryEntry{CapturedExchange: CapturedExchange{
+        Method:        http.MethodGet,
+        Scheme:        "http",
+        Host:          serverURL.Host,
+        Target:        "/profile?user=ivan",
+        Proto:         "HTTP/1.1",
+        RequestFields: HeaderList{{Name: "Host", Value: serverURL.Host}, {Name: "Accept", Value: "application/json"}},
+        StatusCode:    http.StatusOK,
+        Status:        "200 OK",
+        ResponseHeader: http.Header{
+            "Content-Type": {"application/json"},
+            "X-Version":    {"1"},
+        },
+        ResponseBody: CapturedBody{Data: body, Size: int64(len(body))},
+    }}
+    if err := history.Save(entry); err != nil {
+        t.Fatal(err)
+    }
+    return entry
+}
+
+func TestRepeatRequestDiff(t *testing.T) {
+    collectExchanges(t)
+    history := useTestHistory(t)
+    serverURL := startProfileServer(t)
+    original := saveOriginalProfile(t, history, serverURL)
+
+    result, err := RepeatRequest(original.ID, RepeatEdits{
+        Query:      []Param{{Name: "user", Value: "petr"}},
+        SetHeaders: []HeaderField{{Name: "X-Role", Value: "admin"}},
+    })
+    if err != nil {
+        t.Fatalf("RepeatRequest: %v", err)
+    }
+    if result.Error != "" {
+        t.Fatalf("ошибка повтора: %s", result.Error)
+    }
+
+    repeated, err := GetHistoryEntry(result.RepeatID)
+    if err != nil {
+        t.Fatalf("повтор не сохранён в истории: %v", err)
+    }
+    if result.OriginalID != original.ID || repeated.RepeatOf != original.ID || repeated.ID == original.ID {
+        t.Errorf("связь повтора: original=%d repeat=%d repeat_of=%d", result.OriginalID, repeated.ID, repeated.RepeatOf)
+    }
+    if repeated.Target != "/profile?user=petr" || repeated.RequestHeader.Get("X-Role") != "admin" {
+        t.Errorf("повтор отправлен без правок: %s %v", repeated.Target, repeated.RequestHeader)
+    }
+
+    if want := (&ValueChange{Original: "200 OK", Repeated: "201 Created"}); !reflect.DeepEqual(result.Diff.Status, want) {
+        t.Errorf("Diff.Status = %+v, ожидалось %+v", result.Diff.Status, want)
+    }
+
+    var versionChange *HeaderChange
+    for i := range result.Diff.Headers {
+        if result.Diff.Headers[i].Name == "X-Version" {
+            versionChange = &result.Diff.Headers[i]
+        }
+        if result.Diff.Headers[i].Name == "Content-Type" {
+            t.Error("неизменный Content-Type попал в разницу заголовков")
+        }
+    }
+    if versionChange == nil || !reflect.DeepEqual(versionChange.Original, []string{"1"}) || !reflect.DeepEqual(versionChange.Repeated, []string{"2"}) {
+        t.Errorf("изменение X-Version = %+v", versionChange)
+    }
+
+    admin, guest, ivan, petr := "admin", "guest", "ivan", "petr"
+    wantParams := []ParamChange{
+        {Name: "role", Original: &guest, Repeated: &admin},
+        {Name: "user", Original: &ivan, Repeated: &petr},
+    }
+    if !result.Diff.Body.Changed || !reflect.DeepEqual(result.Diff.Body.Params, wantParams) {
+        t.Errorf("Diff.Body = %+v", result.Diff.Body)
+    }
+}
+
+func TestRepeatHandler(t *testing.T) {
+    collectExchanges(t)
+    history := useTestHistory(t)
+    serverURL := startProfileServer(t)
+    original := saveOriginalProfile(t, history, serverURL)
+    handler := NewManagementHandler()
+
+    tests := []struct {
+        name   string
+        id     string
+        body   string
+        status int
+    }{
+        {name: "без изменений", id: fmt.Sprint(original.ID), status: http.StatusOK},
+        {name: "с изменениями", id: fmt.Sprint(original.ID), body: `{"query":[{"name":"user","value":"petr"}]}`, status: http.StatusOK},
+        {name: "некорректный путь", id: fmt.Sprint(original.ID), body: `{"path":"profile"}`, status: http.StatusBadRequest},
+        {name: "неизвестное поле", id: fmt.Sprint(original.ID), body: `{"headers":{}}`, status: http.StatusBadRequest},
+        {name: "несуществующая запись", id: "999", status: http.StatusNotFound},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            request := httptest.NewRequest(http.MethodPost, "/repeat/"+test.id, strings.NewReader(test.body))
+            recorder := httptest.NewRecorder()
+            handler.ServeHTTP(recorder, request)
+
+            if recorder.Code != test.status {
+                t.Fatalf("статус = %d, ожидался %d: %s", recorder.Code, test.status, recorder.Body)
+            }
+            if test.status != http.StatusOK {
+                return
+            }
+
+            var result RepeatResult
+            if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+                t.Fatal(err)
+            }
+            if result.OriginalID != original.ID || result.RepeatID == 0 || result.Response == nil {
+                t.Errorf("результат повтора: %+v", result)
+            }
+        })
+    }
+}
+
+func TestRepeatEditsApply(t *testing.T) {
+    raw := "POST /submit?a=1 HTTP/1.1\r\nHost: example.com\r\nCookie: session=abc\r\nContent-Type: application/json\r\nContent-Length: 8\r\n\r\n{\"a\":1}\n"
+    body := "replaced"
+
+    tests := []struct {
+        name    string
+        edits   RepeatEdits
+        wantErr bool
+        check   func(t *testing.T, request *ParsedRequest)
+    }{
+        {name: "метод и путь", edits: RepeatEdits{Method: "put", Path: "/other"}, check: func(t *testing.T, request *ParsedRequest) {
+            if request.Method != "PUT" || request.Target() != "/other?a=1" {
+                t.Errorf("получено %s %s", request.Method, request.Target())
+            }
+        }},
+        {name: "путь без слеша", edits: RepeatEdits{Path: "other"}, wantErr: true},
+        {name: "заголовок с переводом строки", edits: RepeatEdits{SetHeaders: []HeaderField{{Name: "X-Test", Value: "a\r\nInjected: 1"}}}, wantErr: true},
+        {name: "удаление Cookie", edits: RepeatEdits{RemoveHeaders: []string{"cookie"}}, check: func(t *testing.T, request *ParsedRequest) {
+            if request.Headers.Has("Cookie") || len(request.Cookies) != 0 {
+                t.Errorf("Cookie не удалён: %+v %+v", request.Headers, request.Cookies)
+            }
+        }},
+        {name: "замена тела", edits: RepeatEdits{Body: &body}, check: func(t *testing.T, request *ParsedRequest) {
+            if string(request.Body) != body || request.Headers.Get("Content-Length") != "8" {
+                t.Errorf("тело %q, Content-Length %q", request.Body, request.Headers.Get("Content-Length"))
+            }
+        }},
+        {name: "параметры тела JSON", edits: RepeatEdits{BodyParams: []Param{{Name: "a", Value: "2"}}}, wantErr: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            request, err := ParseRequest([]byte(raw))
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            err = test.edits.apply(request)
+            if test.wantErr {
+                if !errors.Is(err, errInvalidRepeatEdit) {
+                    t.Fatalf("ожидалась errInvalidRepeatEdit, получено %v", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("apply: %v", err)
+            }
+            test.check(t, request)
+        })
+    }
+}
+
+func TestDiffLines(t *testing.T) {
+    tests := []struct {
+        name     string
+        original string
+        repeated string
+        want     []LineChange
+    }{
+        {name: "без изменений", original: "a\nb", repeated: "a\nb"},
+        {name: "замена строки", original: "a\nb\nc", repeated: "a\nx\nc", want: []LineChange{
+            {Op: "+", Line: 2, Text: "x"},
+            {Op: "-", Line: 2, Text: "b"},
+        }},
+        {name: "вставка и удаление", original: "a\nb\nc", repeated: "b\nc\nd", want: []LineChange{
+            {Op: "-", Line: 1, Text: "a"},
+            {Op: "+", Line: 3, Text: "d"},
+        }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got := diffLines(strings.Split(test.original, "\n"), strings.Split(test.repeated, "\n"))
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("diffLines = %+v, ожидалось %+v", got, test.want)
+            }
+        })
+    }
+
+    long := strings.Split(strings.Repeat("x\n", maxDiffLines+1), "\n")
+    if changes := diffLines(long, []string{"y"}); changes != nil {
+        t.Errorf("для слишком длинных тел ожидался nil, получено %d изменений", len(changes))
+    }
+}
+
+func TestDiffParamsAndHeaders(t *testing.T) {
+    one, two := "1", "2"
+    got := diffParams(
+        []Param{{Name: "kept", Value: "1"}, {Name: "changed", Value: "1"}, {Name: "removed", Value: "1"}},
+        []Param{{Name: "kept", Value: "1"}, {Name: "changed", Value: "2"}, {Name: "added", Value: "2"}},
+    )
+    want := []ParamChange{
+        {Name: "changed", Original: &one, Repeated: &two},
+        {Name: "removed", Original: &one},
+        {Name: "added", Repeated: &two},
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("diffParams = %+v, ожидалось %+v", got, want)
+    }
+
+    headers := diffHeaders(
+        http.Header{"Server": {"a"}, "Set-Cookie": {"x=1"}, "X-Same": {"v"}},
+        http.Header{"Server": {"b"}, "X-Same": {"v"}, "X-New": {"n"}},
+    )
+    wantHeaders := []HeaderChange{
+        {Name: "Server", Original: []string{"a"}, Repeated: []string{"b"}},
+        {Name: "Set-Cookie", Original: []string{"x=1"}},
+        {Name: "X-New", Repeated: []string{"n"}},
+    }
+    if !reflect.DeepEqual(headers, wantHeaders) {
+        t.Errorf("diffHeaders = %+v, ожидалось %+v", headers, wantHeaders)
+    }
+}
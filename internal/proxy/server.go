@@ -3,6 +3,8 @@ package proxy
 import (
     "fmt"
     "net"
+    "net/http"
+    "time"
 )
 
 type ProxyServer struct {
@@ -18,6 +20,10 @@ func NewProxyServer() *ProxyServer {
 }
 
 func (s *ProxyServer) Initialize(port string) error {
+    if err := defaultStore.Ready(); err != nil {
+        return fmt.Errorf("хранилище сертификатов не готово: %w", err)
+    }
+
     networkListener, err := s.createListener(port)
     if err != nil {
         return err
@@ -54,10 +60,28 @@ func NewProxyListener(port string) *ProxyListener {
 }
 
 func StartProxy(port string) error {
+    if err := defaultStore.Ready(); err != nil {
+        return fmt.Errorf("хранилище сертификатов не готово: %w", err)
+    }
+
     proxyListener := NewProxyListener(port)
     return proxyListener.serve()
 }
 
+func StartManagementAPI(address string) error {
+    server := &http.Server{
+        Addr:              address,
+        Handler:           NewManagementHandler(),
+        ReadHeaderTimeout: 10 * time.Second,
+    }
+
+    fmt.Printf("API управления запущен на %s\n", address)
+    if err := server.ListenAndServe(); err != nil {
+        return fmt.Errorf("ошибка запуска API управления: %w", err)
+    }
+    return nil
+}
+
 func (p *ProxyListener) serve() error {
     if err := p.initializeListener(); err != nil {
         return fmt.Errorf("ошибка инициализации сервера: %w", err)
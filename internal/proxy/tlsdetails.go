@@ -0,0 +1,184 @@
+package proxy
+
+import (
+    "crypto/ecdsa"
+    "crypto/rsa"
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "slices"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const tlsHistoryLimit = 1024
+
+type TLSDetails struct {
+    ConnectionID     uint64            `json:"connection_id"`
+    ServerName       string            `json:"server_name"`
+    Address          string            `json:"address"`
+    Version          string            `json:"version"`
+    CipherSuite      string            `json:"cipher_suite"`
+    ALPN             string            `json:"alpn,omitempty"`
+    OCSPStaple       []byte            `json:"ocsp_staple,omitempty"`
+    PeerCertificates []CertificateInfo `json:"peer_certificates"`
+    Weaknesses       []string          `json:"weaknesses,omitempty"`
+    HandshakeAt      time.Time         `json:"handshake_at"`
+}
+
+type CertificateInfo struct {
+    Subject            string    `json:"subject"`
+    Issuer             string    `json:"issuer"`
+    SerialNumber       string    `json:"serial_number"`
+    NotBefore          time.Time `json:"not_before"`
+    NotAfter           time.Time `json:"not_after"`
+    Names              []string  `json:"names,omitempty"`
+    SignatureAlgorithm string    `json:"signature_algorithm"`
+    PublicKeyAlgorithm string    `json:"public_key_algorithm"`
+    PublicKeyBits      int       `json:"public_key_bits,omitempty"`
+    SHA256             string    `json:"sha256"`
+    Raw                []byte    `json:"raw"`
+}
+
+type tlsHistory struct {
+    mutex   sync.RWMutex
+    entries map[uint64]*TLSDetails
+    order   []uint64
+}
+
+var (
+    connectionCounter uint64
+    upstreamTLSLog    = &tlsHistory{entries: make(map[uint64]*TLSDetails)}
+)
+
+func nextConnectionID() uint64 {
+    return atomic.AddUint64(&connectionCounter, 1)
+}
+
+func UpstreamTLSDetails(connectionID uint64) (*TLSDetails, bool) {
+    return upstreamTLSLog.get(connectionID)
+}
+
+func RecentUpstreamTLSDetails(limit int) []*TLSDetails {
+    return upstreamTLSLog.recent(limit)
+}
+
+func (h *tlsHistory) add(details *TLSDetails) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    if _, exists := h.entries[details.ConnectionID]; exists {
+        h.order = slices.DeleteFunc(h.order, func(id uint64) bool { return id == details.ConnectionID })
+    }
+    h.entries[details.ConnectionID] = details
+    h.order = append(h.order, details.ConnectionID)
+    for len(h.order) > tlsHistoryLimit {
+        delete(h.entries, h.order[0])
+        h.order = h.order[1:]
+    }
+}
+
+func (h *tlsHistory) get(connectionID uint64) (*TLSDetails, bool) {
+    h.mutex.RLock()
+    defer h.mutex.RUnlock()
+
+    details, exists := h.entries[connectionID]
+    return details, exists
+}
+
+func (h *tlsHistory) recent(limit int) []*TLSDetails {
+    h.mutex.RLock()
+    defer h.mutex.RUnlock()
+
+    if limit <= 0 || limit > len(h.order) {
+        limit = len(h.order)
+    }
+
+    result := make([]*TLSDetails, 0, limit)
+    for i := len(h.order) - 1; i >= 0 && len(result) < limit; i-- {
+        result = append(result, h.entries[h.order[i]])
+    }
+    return result
+}
+
+func collectTLSDetails(connectionID uint64, address string, state tls.ConnectionState) *TLSDetails {
+    details := &TLSDetails{
+        ConnectionID: connectionID,
+        ServerName:   state.ServerName,
+        Address:      address,
+        Version:      tls.VersionName(state.Version),
+        CipherSuite:  tls.CipherSuiteName(state.CipherSuite),
+        ALPN:         state.NegotiatedProtocol,
+        OCSPStaple:   state.OCSPResponse,
+        HandshakeAt:  time.Now(),
+    }
+
+    for _, cert := range state.PeerCertificates {
+        details.PeerCertificates = append(details.PeerCertificates, describeCertificate(cert))
+    }
+
+    details.Weaknesses = findTLSWeaknesses(state)
+    return details
+}
+
+func describeCertificate(cert *x509.Certificate) CertificateInfo {
+    return CertificateInfo{
+        Subject:            cert.Subject.String(),
+        Issuer:             cert.Issuer.String(),
+        SerialNumber:       cert.SerialNumber.String(),
+        NotBefore:          cert.NotBefore,
+        NotAfter:           cert.NotAfter,
+        Names:              certificateNames(cert),
+        SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+        PublicKeyAlgorithm: cert.PublicKeyAlgorithm.String(),
+        PublicKeyBits:      publicKeyBits(cert),
+        SHA256:             certificateFingerprint(cert),
+        Raw:                cert.Raw,
+    }
+}
+
+func publicKeyBits(cert *x509.Certificate) int {
+    switch key := cert.PublicKey.(type) {
+    case *rsa.PublicKey:
+        return key.N.BitLen()
+    case *ecdsa.PublicKey:
+        return key.Curve.Params().BitSize
+    default:
+        return 0
+    }
+}
+
+func findTLSWeaknesses(state tls.ConnectionState) []string {
+    var weaknesses []string
+
+    if state.Version < tls.VersionTLS12 {
+        weaknesses = append(weaknesses, fmt.Sprintf("устаревшая версия протокола %s", tls.VersionName(state.Version)))
+    }
+
+    suite := tls.CipherSuiteName(state.CipherSuite)
+    for _, insecure := range tls.InsecureCipherSuites() {
+        if insecure.ID == state.CipherSuite {
+            weaknesses = append(weaknesses, fmt.Sprintf("небезопасный набор шифров %s", suite))
+        }
+    }
+    if state.Version < tls.VersionTLS13 && !strings.Contains(suite, "ECDHE") {
+        weaknesses = append(weaknesses, fmt.Sprintf("набор шифров %s без прямой секретности", suite))
+    }
+    if strings.Contains(suite, "_CBC_") {
+        weaknesses = append(weaknesses, fmt.Sprintf("набор шифров %s использует режим CBC", suite))
+    }
+
+    for _, cert := range state.PeerCertificates {
+        switch cert.SignatureAlgorithm {
+        case x509.MD5WithRSA, x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1:
+            weaknesses = append(weaknesses, fmt.Sprintf("сертификат %s подписан алгоритмом %s", cert.Subject, cert.SignatureAlgorithm))
+        }
+        if cert.PublicKeyAlgorithm == x509.RSA && publicKeyBits(cert) < 2048 {
+            weaknesses = append(weaknesses, fmt.Sprintf("сертификат %s использует RSA ключ %d бит", cert.Subject, publicKeyBits(cert)))
+        }
+    }
+
+    return weaknesses
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import "testing"
+
+func TestTLSHistoryRedialSameConnection(t *testing.T) {
+    history := &tlsHistory{entries: make(map[uint64]*TLSDetails)}
+
+    history.add(&TLSDetails{ConnectionID: 1, Address: "first"})
+    for id := uint64(2); id < tlsHistoryLimit; id++ {
+        history.add(&TLSDetails{ConnectionID: id})
+    }
+    history.add(&TLSDetails{ConnectionID: 1, Address: "redial"})
+    history.add(&TLSDetails{ConnectionID: tlsHistoryLimit})
+    history.add(&TLSDetails{ConnectionID: tlsHistoryLimit + 1})
+
+    recent := history.recent(0)
+    if len(recent) != len(history.entries) {
+        t.Fatalf("recent вернул %d записей при %d в индексе", len(recent), len(history.entries))
+    }
+    for i, details := range recent {
+        if details == nil {
+            t.Fatalf("recent()[%d] = nil", i)
+        }
+    }
+
+    details, ok := history.get(1)
+    if !ok || details.Address != "redial" {
+        t.Fatalf("get(1) = %+v, %v; ожидалась запись повторного рукопожатия", details, ok)
+    }
+    if _, ok := history.get(2); ok {
+        t.Error("самая старая запись не вытеснена")
+    }
+}
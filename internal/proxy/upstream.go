@@ -0,0 +1,207 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "html"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+type UpstreamTrust struct {
+    mutex         sync.RWMutex
+    roots         *x509.CertPool
+    insecureHosts map[string]bool
+}
+
+type UpstreamVerificationError struct {
+    Host  string
+    Chain []*x509.Certificate
+    Err   error
+}
+
+var upstreamTrust = NewUpstreamTrust()
+
+func NewUpstreamTrust() *UpstreamTrust {
+    return &UpstreamTrust{
+        insecureHosts: make(map[string]bool),
+    }
+}
+
+func (e *UpstreamVerificationError) Error() string {
+    return fmt.Sprintf("сертификат сервера %s не прошёл проверку: %v", e.Host, e.Err)
+}
+
+func (e *UpstreamVerificationError) Unwrap() error {
+    return e.Err
+}
+
+func (e *UpstreamVerificationError) details(connectionID uint64, address string) *TLSDetails {
+    details := &TLSDetails{
+        ConnectionID: connectionID,
+        ServerName:   e.Host,
+        Address:      address,
+        HandshakeAt:  time.Now(),
+    }
+    for _, cert := range e.Chain {
+        details.PeerCertificates = append(details.PeerCertificates, describeCertificate(cert))
+    }
+    return details
+}
+
+func LoadUpstreamCABundle(path string) error {
+    return upstreamTrust.AddBundle(path)
+}
+
+func AllowInsecureUpstream(hosts ...string) {
+    upstreamTrust.AllowInsecure(hosts...)
+}
+
+func (u *UpstreamTrust) AddBundle(path string) error {
+    data, err := readSecureFile(path)
+    if err != nil {
+        return err
+    }
+
+    u.mutex.Lock()
+    defer u.mutex.Unlock()
+
+    roots := u.roots
+    if roots == nil {
+        if roots, err = x509.SystemCertPool(); err != nil {
+            roots = x509.NewCertPool()
+        }
+    }
+
+    if !roots.AppendCertsFromPEM(data) {
+        return fmt.Errorf("в файле %s нет PEM сертификатов", path)
+    }
+    u.roots = roots
+    return nil
+}
+
+func (u *UpstreamTrust) AllowInsecure(hosts ...string) {
+    u.mutex.Lock()
+    defer u.mutex.Unlock()
+
+    for _, host := range hosts {
+        if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+            u.insecureHosts[host] = true
+        }
+    }
+}
+
+func (u *UpstreamTrust) isInsecure(host string) bool {
+    u.mutex.RLock()
+    defer u.mutex.RUnlock()
+
+    host = strings.ToLower(host)
+    if u.insecureHosts[host] {
+        return true
+    }
+    for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+        host = host[i+1:]
+        if u.insecureHosts["*."+host] {
+            return true
+        }
+    }
+    return false
+}
+
+func (u *UpstreamTrust) clientConfig(serverName string) *tls.Config {
+    config := &tls.Config{
+        ServerName:         serverName,
+        InsecureSkipVerify: true,
+        NextProtos:         []string{"http/1.1"},
+    }
+    if u.isInsecure(serverName) {
+        return config
+    }
+
+    u.mutex.RLock()
+    roots := u.roots
+    u.mutex.RUnlock()
+
+    config.VerifyConnection = func(state tls.ConnectionState) error {
+        return verifyUpstreamChain(serverName, roots, state.PeerCertificates)
+    }
+    return config
+}
+
+func verifyUpstreamChain(serverName string, roots *x509.CertPool, chain []*x509.Certificate) error {
+    if len(chain) == 0 {
+        return &UpstreamVerificationError{Host: serverName, Err: errors.New("сервер не предъявил сертификат")}
+    }
+
+    intermediates := x509.NewCertPool()
+    for _, cert := range chain[1:] {
+        intermediates.AddCert(cert)
+    }
+
+    _, err := chain[0].Verify(x509.VerifyOptions{
+        DNSName:       serverName,
+        Roots:         roots,
+        Intermediates: intermediates,
+    })
+    if err != nil {
+        return &UpstreamVerificationError{Host: serverName, Chain: chain, Err: err}
+    }
+    return nil
+}
+
+func dialUpstreamTLS(address, serverName string) (*tls.Conn, error) {
+    dialer := &net.Dialer{Timeout: upstreamProbeTimeout}
+    return tls.DialWithDialer(dialer, "tcp", address, upstreamTrust.clientConfig(serverName))
+}
+
+func writeVerificationFailure(clientConn net.Conn, verifyErr *UpstreamVerificationError) error {
+    body := renderUpstreamErrorPage(verifyErr)
+    response := fmt.Sprintf("HTTP/1.1 502 Bad Gateway\r\n"+
+        "Content-Type: text/html; charset=utf-8\r\n"+
+        "Content-Length: %d\r\n"+
+        "Connection: close\r\n\r\n", len(body))
+
+    _, err := clientConn.Write([]byte(response + body))
+    return err
+}
+
+func renderUpstreamErrorPage(verifyErr *UpstreamVerificationError) string {
+    page := strings.Builder{}
+    page.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Ошибка проверки сертификата</title></head><body>\n")
+    page.WriteString(fmt.Sprintf("<h1>Сертификат %s не прошёл проверку</h1>\n", html.EscapeString(verifyErr.Host)))
+    page.WriteString(fmt.Sprintf("<p>MITM-прокси отказался устанавливать соединение: %s</p>\n", html.EscapeString(verifyErr.Err.Error())))
+
+    if len(verifyErr.Chain) > 0 {
+        page.WriteString("<h2>Цепочка сертификатов сервера</h2>\n<ol>\n")
+        for _, cert := range verifyErr.Chain {
+            page.WriteString("<li><dl>\n")
+            writeDefinition(&page, "Субъект", cert.Subject.String())
+            writeDefinition(&page, "Издатель", cert.Issuer.String())
+            writeDefinition(&page, "Действует с", cert.NotBefore.Format(time.RFC3339))
+            writeDefinition(&page, "Действует до", cert.NotAfter.Format(time.RFC3339))
+            writeDefinition(&page, "Имена", strings.Join(certificateNames(cert), ", "))
+            writeDefinition(&page, "SHA-256", certificateFingerprint(cert))
+            page.WriteString("</dl></li>\n")
+        }
+        page.WriteString("</ol>\n")
+    }
+
+    page.WriteString("</body></html>\n")
+    return page.String()
+}
+
+func writeDefinition(page *strings.Builder, term, value string) {
+    page.WriteString(fmt.Sprintf("<dt>%s</dt><dd>%s</dd>\n", term, html.EscapeString(value)))
+}
+
+func certificateNames(cert *x509.Certificate) []string {
+    names := append([]string{}, cert.DNSNames...)
+    for _, ip := range cert.IPAddresses {
+        names = append(names, ip.String())
+    }
+    return names
+}
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httputil"
+    "strconv"
+    "strings"
+)
+
+func originForm(method, target string) (string, error) {
+    if target == "*" {
+        if method != http.MethodOptions {
+            return "", fmt.Errorf("asterisk-form допустима только для OPTIONS")
+        }
+        return target, nil
+    }
+
+    if strings.HasPrefix(target, "/") {
+        return stripFragment(target), nil
+    }
+
+    scheme, rest, found := strings.Cut(target, "://")
+    if !found || !isHTTPScheme(scheme) {
+        return "", fmt.Errorf("некорректная цель запроса: %q", target)
+    }
+
+    pathStart := strings.IndexAny(rest, "/?#")
+    if pathStart < 0 {
+        pathStart = len(rest)
+    }
+    if rest[:pathStart] == "" {
+        return "", fmt.Errorf("в цели запроса не указан хост: %q", target)
+    }
+
+    origin := stripFragment(rest[pathStart:])
+    switch {
+    case origin == "" && method == http.MethodOptions:
+        return "*", nil
+    case origin == "" || origin[0] != '/':
+        origin = "/" + origin
+    }
+    return origin, nil
+}
+
+func stripFragment(target string) string {
+    if i := strings.IndexByte(target, '#'); i >= 0 {
+        return target[:i]
+    }
+    return target
+}
+
+func isHTTPScheme(scheme string) bool {
+    return strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https")
+}
+
+func writeRequest(w io.Writer, request *http.Request, fields HeaderList) error {
+    writer := bufio.NewWriter(w)
+
+    target := request.RequestURI
+    if target == "" {
+        target = request.URL.RequestURI()
+    }
+
+    host := request.Host
+    if host == "" {
+        host = request.URL.Host
+    }
+
+    header := request.Header.Clone()
+    header.Set("Host", host)
+    header.Del("Content-Length")
+    header.Del("Transfer-Encoding")
+
+    hasBody := request.Body != nil && request.Body != http.NoBody
+    chunked := hasBody && request.ContentLength < 0
+    switch {
+    case chunked:
+        header.Set("Transfer-Encoding", "chunked")
+    case request.ContentLength > 0 || expectsContentLength(request.Method):
+        header.Set("Content-Length", strconv.FormatInt(max(request.ContentLength, 0), 10))
+    }
+
+    if !fields.Has("Host") {
+        fields = append(HeaderList{{Name: "Host"}}, fields...)
+    }
+
+    requestBuilder := strings.Builder{}
+    requestBuilder.WriteString(fmt.Sprintf("%s %s HTTP/1.1\r\n", request.Method, target))
+    fields.merge(header).writeTo(&requestBuilder)
+    requestBuilder.WriteString("\r\n")
+
+    if _, err := writer.WriteString(requestBuilder.String()); err != nil {
+        return err
+    }
+
+    if hasBody {
+        defer request.Body.Close()
+        if err := writeBody(writer, request.Body, request.ContentLength, request.Trailer, chunked); err != nil {
+            return err
+        }
+    }
+    return writer.Flush()
+}
+
+func expectsContentLength(method string) bool {
+    return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+func writeResponse(w io.Writer, response *http.Response, fields HeaderList) error {
+    writer := bufio.NewWriter(w)
+
+    header := response.Header.Clone()
+    bodyless := !responseHasBody(response)
+    chunked := len(response.TransferEncoding) > 0 && response.TransferEncoding[0] == "chunked"
+
+    switch {
+    case bodyless:
+        if chunked {
+            header.Set("Transfer-Encoding", "chunked")
+        }
+    case chunked:
+        header.Del("Content-Length")
+        header.Set("Transfer-Encoding", "chunked")
+    case response.ContentLength >= 0:
+        header.Del("Transfer-Encoding")
+        header.Set("Content-Length", strconv.FormatInt(response.ContentLength, 10))
+    default:
+        header.Del("Content-Length")
+        header.Del("Transfer-Encoding")
+        response.Close = true
+    }
+
+    if response.Close {
+        header.Set("Connection", "close")
+    }
+
+    status := response.Status
+    if status == "" {
+        status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+    }
+    major, minor := response.ProtoMajor, response.ProtoMinor
+    if major == 0 {
+        major, minor = 1, 1
+    }
+
+    responseBuilder := strings.Builder{}
+    responseBuilder.WriteString(fmt.Sprintf("HTTP/%d.%d %s\r\n", major, minor, status))
+    fields.merge(header).writeTo(&responseBuilder)
+    responseBuilder.WriteString("\r\n")
+
+    if _, err := writer.WriteString(responseBuilder.String()); err != nil {
+        return err
+    }
+
+    if !bodyless && response.Body != nil {
+        contentLength := response.ContentLength
+        if !chunked && contentLength < 0 {
+            if _, err := io.Copy(writer, response.Body); err != nil {
+                return err
+            }
+        } else if err := writeBody(writer, response.Body, contentLength, response.Trailer, chunked); err != nil {
+            return err
+        }
+    }
+    return writer.Flush()
+}
+
+func responseHasBody(response *http.Response) bool {
+    switch {
+    case response.StatusCode/100 == 1,
+        response.StatusCode == http.StatusNoContent,
+        response.StatusCode == http.StatusNotModified:
+        return false
+    case response.Request != nil && response.Request.Method == http.MethodHead:
+        return false
+    }
+    return true
+}
+
+func writeBody(writer *bufio.Writer, body io.Reader, contentLength int64, trailer http.Header, chunked bool) error {
+    if !chunked {
+        written, err := io.Copy(writer, io.LimitReader(body, contentLength))
+        if err != nil {
+            return err
+        }
+        if written != contentLength {
+            return fmt.Errorf("тело сообщения короче Content-Length: %d из %d", written, contentLength)
+        }
+        return nil
+    }
+
+    chunkedWriter := httputil.NewChunkedWriter(writer)
+    if _, err := io.Copy(chunkedWriter, body); err != nil {
+        return err
+    }
+    if err := chunkedWriter.Close(); err != nil {
+        return err
+    }
+
+    trailerBuilder := strings.Builder{}
+    for key, values := range trailer {
+        for _, value := range values {
+            trailerBuilder.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+        }
+    }
+    trailerBuilder.WriteString("\r\n")
+
+    _, err := writer.WriteString(trailerBuilder.String())
+    return err
+}
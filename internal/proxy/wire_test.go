@@ -0,0 +1,53 @@
+package proxy
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestOriginForm(t *testing.T) {
+    tests := []struct {
+        name    string
+        method  string
+        target  string
+        want    string
+        wantErr bool
+    }{
+        {name: "asterisk-form", method: http.MethodOptions, target: "*", want: "*"},
+        {name: "asterisk-form не для OPTIONS", method: http.MethodGet, target: "*", wantErr: true},
+        {name: "authority-form", method: http.MethodGet, target: "example.com:443", wantErr: true},
+        {name: "origin-form", method: http.MethodGet, target: "/a/b?x=1", want: "/a/b?x=1"},
+        {name: "закодированный путь", method: http.MethodGet, target: "/a%2Fb/%2e%2e/c?q=%20x%26", want: "/a%2Fb/%2e%2e/c?q=%20x%26"},
+        {name: "absolute-form с закодированным путём", method: http.MethodGet, target: "http://example.com/a%2Fb?q=%2F", want: "/a%2Fb?q=%2F"},
+        {name: "absolute-form без пути", method: http.MethodGet, target: "http://example.com", want: "/"},
+        {name: "absolute-form только с запросом", method: http.MethodGet, target: "http://example.com?q=1", want: "/?q=1"},
+        {name: "OPTIONS с пустым путём", method: http.MethodOptions, target: "http://example.com", want: "*"},
+        {name: "OPTIONS с корневым путём", method: http.MethodOptions, target: "http://example.com/", want: "/"},
+        {name: "фрагмент в origin-form", method: http.MethodGet, target: "/page?q=1#section", want: "/page?q=1"},
+        {name: "фрагмент в absolute-form", method: http.MethodGet, target: "https://example.com/page#section", want: "/page"},
+        {name: "IPv6 хост", method: http.MethodGet, target: "http://[::1]:8080/x?y=1", want: "/x?y=1"},
+        {name: "схема в верхнем регистре", method: http.MethodGet, target: "HTTPS://Example.COM/Path", want: "/Path"},
+        {name: "схема не HTTP", method: http.MethodGet, target: "ftp://example.com/file", wantErr: true},
+        {name: "нет хоста", method: http.MethodGet, target: "http:///path", wantErr: true},
+        {name: "нет хоста и пути", method: http.MethodGet, target: "http://", wantErr: true},
+        {name: "относительная цель", method: http.MethodGet, target: "path/without/slash", wantErr: true},
+    }
+
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            got, err := originForm(tc.method, tc.target)
+            if tc.wantErr {
+                if err == nil {
+                    t.Fatalf("originForm(%s, %q) = %q, ожидалась ошибка", tc.method, tc.target, got)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("originForm(%s, %q): %v", tc.method, tc.target, err)
+            }
+            if got != tc.want {
+                t.Fatalf("originForm(%s, %q) = %q, ожидалось %q", tc.method, tc.target, got, tc.want)
+            }
+        })
+    }
+}